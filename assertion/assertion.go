@@ -0,0 +1,165 @@
+//Package assertion signs and verifies the identity assertions oauth2_central
+//hands to the services after a successful authentication.
+//
+//The assertion is a short lived HS256 JWT signed with the secret of the service's audience,
+//derived from the assertion_secret with AudienceSecret, so that a service can't sign assertions
+//for the other services. Services verify it with Verify using their secret, their own origin as audience,
+//the state they sent to /oauth2/start and a ReplayCache accepting each assertion once.
+package assertion
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// allowedClockSkew is the leeway given to exp and iat checks
+const allowedClockSkew = 30 * time.Second
+
+//Errors returned by Verify
+var (
+	ErrMalformed        = errors.New("assertion is malformed")
+	ErrInvalidSignature = errors.New("assertion signature is invalid")
+	ErrExpired          = errors.New("assertion expired")
+	ErrAudience         = errors.New("assertion not issued for this audience")
+	ErrState            = errors.New("assertion not bound to this state")
+	ErrReplayed         = errors.New("assertion has no ID or was used already")
+)
+
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+//Claims holds the identity carried by the assertion
+type Claims struct {
+	ID            string `json:"jti"`
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Audience      string `json:"aud"`
+	IssuedAt      int64  `json:"iat"`
+	ExpiresAt     int64  `json:"exp"`
+	State         string `json:"state"`
+	Provider      string `json:"provider"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+//Sign returns the claims as a JWT signed with the secret
+func Sign(claims *Claims, secret []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(signingInput, secret)), nil
+}
+
+//AudienceSecret returns the secret of the audience derived from the assertion_secret.
+//audience is the origin (scheme://host) of the service, which is given only its own secret
+func AudienceSecret(assertionSecret []byte, audience string) []byte {
+	mac := hmac.New(sha256.New, assertionSecret)
+	mac.Write([]byte("oauth2_central assertion audience\n" + audience))
+	return mac.Sum(nil)
+}
+
+//Verify checks the signature of the assertion and that it was issued for the audience
+//and state, then records its ID in the replays so that it is accepted only once.
+//secret is the AudienceSecret of the audience, the origin (scheme://host) of the service verifying the assertion.
+//The state can't be empty, it binds the assertion to the login the service started
+func Verify(token string, secret []byte, audience string, state string, replays ReplayCache) (*Claims, error) {
+	if state == "" {
+		return nil, ErrState
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return nil, ErrMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if !hmac.Equal(signature, sign(parts[0]+"."+parts[1], secret)) {
+		return nil, ErrInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	claims := &Claims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	now := time.Now()
+	if now.Add(-allowedClockSkew).After(time.Unix(claims.ExpiresAt, 0)) ||
+		now.Add(allowedClockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, ErrExpired
+	}
+
+	if claims.Audience != audience {
+		return nil, ErrAudience
+	}
+
+	if !hmac.Equal([]byte(claims.State), []byte(state)) {
+		return nil, ErrState
+	}
+
+	if claims.ID == "" || !replays.Record(claims.ID, time.Unix(claims.ExpiresAt, 0).Add(allowedClockSkew)) {
+		return nil, ErrReplayed
+	}
+
+	return claims, nil
+}
+
+//ReplayCache records the IDs of the verified assertions until they expire.
+//Services running several replicas need a cache shared between them
+type ReplayCache interface {
+	//Record records the ID until expiresAt and reports whether it wasn't recorded already
+	Record(id string, expiresAt time.Time) bool
+}
+
+//MemoryReplayCache is a ReplayCache for the services running a single replica
+type MemoryReplayCache struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+//NewMemoryReplayCache returns an empty MemoryReplayCache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{ids: make(map[string]time.Time)}
+}
+
+//Record records the ID until expiresAt and reports whether it wasn't recorded already
+func (c *MemoryReplayCache) Record(id string, expiresAt time.Time) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for recorded, recordedExpiresAt := range c.ids {
+		if now.After(recordedExpiresAt) {
+			delete(c.ids, recorded)
+		}
+	}
+
+	if _, ok := c.ids[id]; ok {
+		return false
+	}
+
+	c.ids[id] = expiresAt
+	return true
+}
+
+func sign(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,89 @@
+package assertion
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	secret := AudienceSecret([]byte("a very long secret used to sign the assertions"), "https://app.example.com")
+	newClaims := func(lifetime time.Duration) *Claims {
+		now := time.Now()
+		return &Claims{
+			ID:            "1234",
+			Issuer:        "https://sso.example.com",
+			Subject:       "john@example.com",
+			Audience:      "https://app.example.com",
+			IssuedAt:      now.Unix(),
+			ExpiresAt:     now.Add(lifetime).Unix(),
+			State:         "app_state",
+			Provider:      "google",
+			Name:          "John",
+			Email:         "john@example.com",
+			EmailVerified: true,
+		}
+	}
+
+	valid, err := Sign(newClaims(time.Minute), secret)
+	assert.Nil(t, err)
+	expired, err := Sign(newClaims(-time.Hour), secret)
+	assert.Nil(t, err)
+	otherSecret, err := Sign(newClaims(time.Minute), []byte("some other secret"))
+	assert.Nil(t, err)
+	otherAudience, err := Sign(newClaims(time.Minute),
+		AudienceSecret([]byte("a very long secret used to sign the assertions"), "https://evil.example.com"))
+	assert.Nil(t, err)
+	noState := newClaims(time.Minute)
+	noState.State = ""
+	withoutState, err := Sign(noState, secret)
+	assert.Nil(t, err)
+	noID := newClaims(time.Minute)
+	noID.ID = ""
+	withoutID, err := Sign(noID, secret)
+	assert.Nil(t, err)
+
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+
+	tests := []struct {
+		token         string
+		audience      string
+		state         string
+		expectedError error
+	}{
+		{token: valid, audience: "https://app.example.com", state: "app_state"},
+		{token: valid, audience: "https://app.example.com", state: "app_state", expectedError: ErrReplayed},
+		{token: valid, audience: "https://evil.example.com", state: "app_state", expectedError: ErrAudience},
+		{token: valid, audience: "https://app.example.com", state: "other_state", expectedError: ErrState},
+		{token: expired, audience: "https://app.example.com", state: "app_state", expectedError: ErrExpired},
+		{token: otherSecret, audience: "https://app.example.com", state: "app_state", expectedError: ErrInvalidSignature},
+		{token: otherAudience, audience: "https://app.example.com", state: "app_state", expectedError: ErrInvalidSignature},
+		{token: withoutState, audience: "https://app.example.com", state: "", expectedError: ErrState},
+		{token: withoutID, audience: "https://app.example.com", state: "app_state", expectedError: ErrReplayed},
+		{token: tampered, audience: "https://app.example.com", state: "app_state", expectedError: ErrInvalidSignature},
+		{token: "not.a.token", audience: "https://app.example.com", state: "app_state", expectedError: ErrMalformed},
+	}
+
+	replays := NewMemoryReplayCache()
+	for _, test := range tests {
+		claims, err := Verify(test.token, secret, test.audience, test.state, replays)
+		assert.Equal(t, test.expectedError, err)
+		if test.expectedError == nil {
+			assert.Equal(t, newClaims(time.Minute).Email, claims.Email)
+			assert.Equal(t, "google", claims.Provider)
+		}
+	}
+}
+
+func TestMemoryReplayCache_Record(t *testing.T) {
+	replays := NewMemoryReplayCache()
+	assert.True(t, replays.Record("1234", time.Now().Add(time.Minute)))
+	assert.False(t, replays.Record("1234", time.Now().Add(time.Minute)))
+
+	// the expired IDs are forgotten
+	assert.True(t, replays.Record("5678", time.Now().Add(-time.Second)))
+	assert.True(t, replays.Record("5678", time.Now().Add(time.Minute)))
+}
@@ -1,12 +1,20 @@
 package config
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 )
 
-type config struct {
+//Configuration holds all the configurations of the oauth central
+type Configuration struct {
 	Port               string `json:"port"`
 	TLSKey             string `json:"tls_key"`
 	TLSCert            string `json:"tls_cert"`
@@ -24,33 +32,399 @@ type config struct {
 	GithubAllowSignUp bool `json:"github_allow_signup"`
 	CookieHTTPOnly    bool `json:"cookie_http_only"`
 	CookieSecure      bool `json:"cookie_secure"`
+
+	Providers        []ProviderConfig `json:"providers"`
+	AllowedRedirects []string         `json:"allowed_redirects"`
+	AllowedOrigins   []string         `json:"allowed_origins"`
+
+	AssertionSecret      string `json:"assertion_secret"`
+	LegacyRedirectParams bool   `json:"legacy_redirect_params"`
+
+	ExternalURL          string `json:"external_url"`
+	AuthenticateRedirect bool   `json:"authenticate_redirect"`
+
+	Upstreams       []UpstreamConfig `json:"upstreams"`
+	PassAccessToken bool             `json:"pass_access_token"`
+
+	SessionStore     string `json:"session_store"`
+	SessionStorePath string `json:"session_store_path"`
+	RedisAddress     string `json:"redis_address"`
+	RedisPassword    string `json:"redis_password"`
+	RedisDB          int    `json:"redis_db"`
+	RedisPoolSize    int    `json:"redis_pool_size"`
+
+	CookieEncryptionKey  string   `json:"cookie_encryption_key"`
+	CookieSecrets        []string `json:"cookie_secrets"`
+	CookieEncryptionKeys []string `json:"cookie_encryption_keys"`
+
+	CookieDomains  []string `json:"cookie_domains"`
+	CookiePath     string   `json:"cookie_path"`
+	CookieSameSite string   `json:"cookie_same_site"`
+	CookiePrefix   string   `json:"cookie_prefix"`
+
+	SessionIdleTimeout string `json:"session_idle_timeout"`
+	SessionLifetime    string `json:"session_lifetime"`
+
+	GithubOrgs  []string `json:"github_orgs"`
+	GithubTeams []string `json:"github_teams"`
+
+	TrustedProxies []string `json:"trusted_proxies"`
 }
 
-//Config is the singleton holding all the configurations of the oauth central
-var Config = config{}
+//ProviderConfig holds the configuration of a named provider
+type ProviderConfig struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	AuthScope    string `json:"auth_scope"`
+	IssuerURL    string `json:"issuer_url"`
+
+	BaseURL     string   `json:"base_url"`
+	APIURL      string   `json:"api_url"`
+	AllowSignUp bool     `json:"allow_signup"`
+	Orgs        []string `json:"orgs"`
+	Teams       []string `json:"teams"`
+
+	Groups   []string `json:"groups"`
+	Projects []string `json:"projects"`
+
+	Tenant         string   `json:"tenant"`
+	AllowedTenants []string `json:"allowed_tenants"`
+
+	Workspaces []string `json:"workspaces"`
+	TeamID     string   `json:"team_id"`
+	Guilds     []string `json:"guilds"`
+
+	ServerURL          string `json:"server_url"`
+	StartTLS           bool   `json:"start_tls"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	BindDN             string `json:"bind_dn"`
+	BindPassword       string `json:"bind_password"`
+	UserBaseDN         string `json:"user_base_dn"`
+	UserFilter         string `json:"user_filter"`
+	GroupBaseDN        string `json:"group_base_dn"`
+	GroupFilter        string `json:"group_filter"`
+	GroupAttribute     string `json:"group_attribute"`
+	EmailAttribute     string `json:"email_attribute"`
+	NameAttribute      string `json:"name_attribute"`
+
+	UsersFile        string `json:"users_file"`
+	MaxLoginAttempts int    `json:"max_login_attempts"`
+	LockoutDuration  string `json:"lockout_duration"`
+}
+
+//Config is the configuration loaded on startup. The requests use the snapshot built from it by sessions.InitiateStores,
+//which is replaced as a whole on reload
+var Config = Configuration{}
 
 //LoadConfigFile loads all the configurations given in the config file.
 //if filePath is empty, will revert back to config.json
 func LoadConfigFile(filePath string) error {
+	loaded, err := ReadConfigFile(filePath)
+	if err != nil {
+		return err
+	}
 
-	if filePath == "" {
-		filePath = "config.json"
+	Config = loaded
+	log.Println("loaded configuration from " + configFilePath(filePath))
+	return nil
+}
+
+//ReadConfigFile reads and validates the configurations given in the config file without loading them,
+//so that a reload can prepare everything before replacing the configuration in effect.
+//if filePath is empty, will revert back to config.json
+func ReadConfigFile(filePath string) (Configuration, error) {
+	file, err := os.Open(configFilePath(filePath))
+	if err != nil {
+		return Configuration{}, err
 	}
+	defer file.Close()
 
-	file, err := os.Open(filePath)
+	var loaded Configuration
+	err = json.NewDecoder(file).Decode(&loaded)
+	if err != nil {
+		return Configuration{}, err
+	}
 
+	err = loaded.validate()
 	if err != nil {
-		return err
+		return Configuration{}, err
+	}
+
+	if loaded.AssertionSecret == "" && !loaded.LegacyRedirectParams {
+		log.Println("deprecated: no assertion_secret, the identity is passed as the legacy redirect params. " +
+			"Set an assertion_secret to pass a signed assertion instead")
+		loaded.LegacyRedirectParams = true
+	}
+	return loaded, nil
+}
+
+func configFilePath(filePath string) string {
+	if filePath == "" {
+		return "config.json"
+	}
+
+	return filePath
+}
+
+//RestartSettingsChanged returns the settings differing in other that are only read on startup,
+//the listening address, TLS and the session store backend, so that a reload can reject them
+func (c Configuration) RestartSettingsChanged(other Configuration) []string {
+	settings := []struct {
+		name           string
+		current, other interface{}
+	}{
+		{"port", c.Port, other.Port},
+		{"tls_cert", c.TLSCert, other.TLSCert},
+		{"tls_key", c.TLSKey, other.TLSKey},
+		{"session_store", c.SessionStore, other.SessionStore},
+		{"session_store_path", c.SessionStorePath, other.SessionStorePath},
+		{"redis_address", c.RedisAddress, other.RedisAddress},
+		{"redis_password", c.RedisPassword, other.RedisPassword},
+		{"redis_db", c.RedisDB, other.RedisDB},
+		{"redis_pool_size", c.RedisPoolSize, other.RedisPoolSize},
+	}
+
+	var changed []string
+	for _, setting := range settings {
+		if setting.current != setting.other {
+			changed = append(changed, setting.name)
+		}
 	}
-	err = json.NewDecoder(file).Decode(&Config)
+	return changed
+}
+
+const (
+	// minAssertionSecretLength is the minimum length of the assertion secret in bytes
+	minAssertionSecretLength = 32
+
+	// minCookieSecretLength is the minimum length of the cookie secret in bytes
+	minCookieSecretLength = 32
+)
+
+// validate checks the loaded configuration for insecure or missing values
+func (c Configuration) validate() error {
+	if c.CookieSecret != "" && len(c.CookieSecrets) > 0 {
+		return errors.New("cookie_secret and cookie_secrets are mutually exclusive")
+	}
+
+	if c.CookieEncryptionKey != "" && len(c.CookieEncryptionKeys) > 0 {
+		return errors.New("cookie_encryption_key and cookie_encryption_keys are mutually exclusive")
+	}
+
+	secrets := c.GetCookieSecrets()
+	if len(secrets) == 0 {
+		return errors.New("cookie_secret is required")
+	}
+
+	for _, secret := range secrets {
+		if len(secret) < minCookieSecretLength {
+			return errors.New("cookie secrets must be at least 32 bytes long")
+		}
+	}
+
+	for _, encodedKey := range c.GetCookieEncryptionKeys() {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return errors.New("cookie encryption keys must be base64 encoded")
+		}
+
+		if length := len(key); length != 16 && length != 24 && length != 32 {
+			return errors.New("cookie encryption keys must be 16, 24 or 32 bytes long")
+		}
+	}
+
+	err := c.validateCookieOptions()
 	if err != nil {
 		return err
 	}
-	log.Println("loaded configuration from " + filePath)
+
+	if c.AssertionSecret != "" && len(c.AssertionSecret) < minAssertionSecretLength {
+		return errors.New("assertion_secret must be at least 32 bytes long")
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("trusted proxy %q must be an IP address or a CIDR range", proxy)
+		}
+	}
+
+	for _, providerConfig := range c.Providers {
+		if providerConfig.Type == "ldap" {
+			if err := providerConfig.validateLDAP(); err != nil {
+				return err
+			}
+		}
+
+		if providerConfig.Type == "local" {
+			if err := providerConfig.validateLocal(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateLDAP checks the ldap provider has a directory to bind against, an account to bind with and users to look up
+func (p ProviderConfig) validateLDAP() error {
+	serverURL, err := url.Parse(p.ServerURL)
+	if err != nil || (serverURL.Scheme != "ldap" && serverURL.Scheme != "ldaps") || serverURL.Host == "" {
+		return fmt.Errorf("provider %s: server_url must be an ldap:// or ldaps:// url", p.Name)
+	}
+
+	if serverURL.Scheme == "ldaps" && p.StartTLS {
+		return fmt.Errorf("provider %s: start_tls can't be used with ldaps://", p.Name)
+	}
+
+	if p.UserBaseDN == "" {
+		return fmt.Errorf("provider %s: user_base_dn is required", p.Name)
+	}
+
+	// the users are looked up again with the bind_dn on refresh, which anonymous binds can't be trusted with
+	if p.BindDN == "" {
+		return fmt.Errorf("provider %s: bind_dn is required", p.Name)
+	}
+
 	return nil
 }
 
+// validateLocal checks the local provider has a users file and a usable lockout
+func (p ProviderConfig) validateLocal() error {
+	if p.UsersFile == "" {
+		return fmt.Errorf("provider %s: users_file is required", p.Name)
+	}
+
+	if p.MaxLoginAttempts < 0 {
+		return fmt.Errorf("provider %s: max_login_attempts can't be negative", p.Name)
+	}
+
+	if p.LockoutDuration != "" {
+		duration, err := time.ParseDuration(p.LockoutDuration)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("provider %s: lockout_duration must be a positive duration, ex: 15m", p.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateCookieOptions rejects the cookie options browsers would refuse or that weaken the cookies
+func (c Configuration) validateCookieOptions() error {
+	if c.IsSecure() && !c.CookieSecure {
+		return errors.New("cookie_secure must be enabled when serving over TLS")
+	}
+
+	if c.CookiePath != "" && !strings.HasPrefix(c.CookiePath, "/") {
+		return errors.New("cookie_path must start with /")
+	}
+
+	for _, domain := range c.CookieDomains {
+		if !strings.Contains(strings.Trim(domain, "."), ".") {
+			return fmt.Errorf("cookie domain %q must have at least two labels", domain)
+		}
+	}
+
+	switch c.CookieSameSite {
+	case "", "lax", "strict":
+	case "none":
+		if !c.CookieSecure {
+			return errors.New("cookie_same_site none requires cookie_secure")
+		}
+	default:
+		return fmt.Errorf("unknown cookie_same_site %q", c.CookieSameSite)
+	}
+
+	switch c.CookiePrefix {
+	case "":
+	case "__Secure-":
+		if !c.CookieSecure {
+			return errors.New("cookie_prefix __Secure- requires cookie_secure")
+		}
+	case "__Host-":
+		if !c.CookieSecure {
+			return errors.New("cookie_prefix __Host- requires cookie_secure")
+		}
+
+		if len(c.CookieDomains) > 0 || (c.CookiePath != "" && c.CookiePath != "/") {
+			return errors.New("cookie_prefix __Host- requires no cookie_domains and the / cookie_path")
+		}
+	default:
+		return fmt.Errorf("unknown cookie_prefix %q", c.CookiePrefix)
+	}
+
+	return nil
+}
+
+//GetCookieSecrets returns the cookie secrets, newest first.
+//The newest secret signs the cookies while the older ones are only accepted
+func (c Configuration) GetCookieSecrets() []string {
+	if len(c.CookieSecrets) > 0 {
+		return c.CookieSecrets
+	}
+
+	if c.CookieSecret == "" {
+		return nil
+	}
+
+	return []string{c.CookieSecret}
+}
+
+//GetCookieEncryptionKeys returns the base64 encoded cookie encryption keys, newest first.
+//Empty if the keys are to be derived from the cookie secrets
+func (c Configuration) GetCookieEncryptionKeys() []string {
+	if len(c.CookieEncryptionKeys) > 0 {
+		return c.CookieEncryptionKeys
+	}
+
+	if c.CookieEncryptionKey == "" {
+		return nil
+	}
+
+	return []string{c.CookieEncryptionKey}
+}
+
 //IsSecure determines whether oauth is serving over HTTPS
-func (c config) IsSecure() bool {
+func (c Configuration) IsSecure() bool {
 	return c.TLSCert != "" && c.TLSKey != ""
 }
+
+//UpstreamConfig holds the upstream authenticated requests under the path are proxied to
+type UpstreamConfig struct {
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+	Provider string `json:"provider"`
+}
+
+//GetProviderConfig returns the configuration of the named provider
+func (c Configuration) GetProviderConfig(name string) (ProviderConfig, bool) {
+	for _, providerConfig := range c.Providers {
+		if providerConfig.Name == name {
+			return providerConfig, true
+		}
+	}
+	return ProviderConfig{}, false
+}
+
+//IsTrustedProxy reports whether the IP address is one of the trusted_proxies, given as IP addresses or CIDR ranges
+func (c Configuration) IsTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
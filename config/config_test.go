@@ -1,6 +1,8 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/bmizerany/assert"
@@ -25,3 +27,130 @@ func TestLoadConfigFile(t *testing.T) {
 		assert.Equal(t, result, test.expectedResult)
 	}
 }
+
+func TestReadConfigFile_LegacyRedirectParams(t *testing.T) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	// without an assertion_secret the identity is passed as the legacy params rather than refusing to start
+	file.WriteString(`{"cookie_secret": "a very long secret used to sign the test cookies"}`)
+	file.Close()
+
+	loaded, err := ReadConfigFile(file.Name())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, loaded.LegacyRedirectParams, true)
+}
+
+func TestConfig_validateCookieOptions(t *testing.T) {
+
+	cases := []struct {
+		config         Configuration
+		expectedResult bool
+	}{
+		{config: Configuration{}, expectedResult: true},
+		{config: Configuration{TLSCert: "cert", TLSKey: "key"}, expectedResult: false},
+		{config: Configuration{TLSCert: "cert", TLSKey: "key", CookieSecure: true}, expectedResult: true},
+		{config: Configuration{CookiePath: "app"}, expectedResult: false},
+		{config: Configuration{CookieDomains: []string{".mydomain.com"}}, expectedResult: true},
+		{config: Configuration{CookieDomains: []string{"com"}}, expectedResult: false},
+		{config: Configuration{CookieSameSite: "strict"}, expectedResult: true},
+		{config: Configuration{CookieSameSite: "none"}, expectedResult: false},
+		{config: Configuration{CookieSameSite: "none", CookieSecure: true}, expectedResult: true},
+		{config: Configuration{CookieSameSite: "relaxed"}, expectedResult: false},
+		{config: Configuration{CookiePrefix: "__Secure-"}, expectedResult: false},
+		{config: Configuration{CookiePrefix: "__Secure-", CookieSecure: true, CookieDomains: []string{"mydomain.com"}}, expectedResult: true},
+		{config: Configuration{CookiePrefix: "__Host-", CookieSecure: true}, expectedResult: true},
+		{config: Configuration{CookiePrefix: "__Host-", CookieSecure: true, CookiePath: "/app"}, expectedResult: false},
+		{config: Configuration{CookiePrefix: "__Host-", CookieSecure: true, CookieDomains: []string{"mydomain.com"}}, expectedResult: false},
+		{config: Configuration{CookiePrefix: "__Other-"}, expectedResult: false},
+	}
+
+	for _, test := range cases {
+		err := test.config.validateCookieOptions()
+		assert.Equal(t, err == nil, test.expectedResult)
+	}
+}
+
+func TestProviderConfig_validateLDAP(t *testing.T) {
+
+	cases := []struct {
+		config         ProviderConfig
+		expectedResult bool
+	}{
+		{config: ProviderConfig{ServerURL: "ldap://ldap.example.com", UserBaseDN: "ou=people,dc=example,dc=com", BindDN: "cn=service,dc=example,dc=com"}, expectedResult: true},
+		{config: ProviderConfig{ServerURL: "ldaps://ldap.example.com:636", UserBaseDN: "ou=people,dc=example,dc=com", BindDN: "cn=service,dc=example,dc=com"}, expectedResult: true},
+		{config: ProviderConfig{ServerURL: "ldap://ldap.example.com", UserBaseDN: "ou=people,dc=example,dc=com", BindDN: "cn=service,dc=example,dc=com", StartTLS: true}, expectedResult: true},
+		{config: ProviderConfig{ServerURL: "ldaps://ldap.example.com", UserBaseDN: "ou=people,dc=example,dc=com", BindDN: "cn=service,dc=example,dc=com", StartTLS: true}, expectedResult: false},
+		{config: ProviderConfig{ServerURL: "https://ldap.example.com", UserBaseDN: "ou=people,dc=example,dc=com"}, expectedResult: false},
+		{config: ProviderConfig{ServerURL: "ldap.example.com", UserBaseDN: "ou=people,dc=example,dc=com"}, expectedResult: false},
+		{config: ProviderConfig{ServerURL: "ldap://ldap.example.com"}, expectedResult: false},
+		{config: ProviderConfig{ServerURL: "ldap://ldap.example.com", UserBaseDN: "ou=people,dc=example,dc=com", BindPassword: "secret"}, expectedResult: false},
+		{config: ProviderConfig{ServerURL: "ldap://ldap.example.com", UserBaseDN: "ou=people,dc=example,dc=com"}, expectedResult: false},
+	}
+
+	for _, test := range cases {
+		err := test.config.validateLDAP()
+		assert.Equal(t, err == nil, test.expectedResult)
+	}
+}
+
+func TestProviderConfig_validateLocal(t *testing.T) {
+
+	cases := []struct {
+		config         ProviderConfig
+		expectedResult bool
+	}{
+		{config: ProviderConfig{UsersFile: "users.htpasswd"}, expectedResult: true},
+		{config: ProviderConfig{UsersFile: "users.json", MaxLoginAttempts: 3, LockoutDuration: "1h"}, expectedResult: true},
+		{config: ProviderConfig{}, expectedResult: false},
+		{config: ProviderConfig{UsersFile: "users.htpasswd", MaxLoginAttempts: -1}, expectedResult: false},
+		{config: ProviderConfig{UsersFile: "users.htpasswd", LockoutDuration: "15"}, expectedResult: false},
+		{config: ProviderConfig{UsersFile: "users.htpasswd", LockoutDuration: "-5m"}, expectedResult: false},
+	}
+
+	for _, test := range cases {
+		err := test.config.validateLocal()
+		assert.Equal(t, err == nil, test.expectedResult)
+	}
+}
+
+func TestConfig_RestartSettingsChanged(t *testing.T) {
+	current := Configuration{Port: "8080", SessionStore: "redis", RedisAddress: "localhost:6379", CookieSecret: "secret"}
+
+	cases := []struct {
+		reloaded Configuration
+		changed  []string
+	}{
+		{reloaded: current},
+		{reloaded: Configuration{Port: "8080", SessionStore: "redis", RedisAddress: "localhost:6379", CookieSecret: "rotated"}},
+		{reloaded: Configuration{Port: "8080", SessionStore: "bolt", SessionStorePath: "sessions.db"}, changed: []string{"session_store", "session_store_path", "redis_address"}},
+		{reloaded: Configuration{Port: "9090", SessionStore: "redis", RedisAddress: "localhost:6379", RedisDB: 1}, changed: []string{"port", "redis_db"}},
+	}
+
+	for _, test := range cases {
+		assert.Equal(t, current.RestartSettingsChanged(test.reloaded), test.changed)
+	}
+}
+
+func TestConfig_IsTrustedProxy(t *testing.T) {
+	c := Configuration{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}}
+
+	cases := []struct {
+		address        string
+		expectedResult bool
+	}{
+		{address: "10.1.2.3", expectedResult: true},
+		{address: "192.0.2.1", expectedResult: true},
+		{address: "2001:db8::1", expectedResult: true},
+		{address: "192.0.2.2", expectedResult: false},
+		{address: "11.0.0.1", expectedResult: false},
+		{address: "not an address", expectedResult: false},
+	}
+
+	for _, test := range cases {
+		assert.Equal(t, c.IsTrustedProxy(test.address), test.expectedResult)
+	}
+}
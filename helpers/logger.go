@@ -1,7 +1,10 @@
 package helpers
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 )
@@ -50,3 +53,23 @@ func (w *responseWriter) WriteHeader(header int) {
 	w.W.WriteHeader(header)
 	w.status = header
 }
+
+//Hijack lets the proxied websocket connections take over the connection
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.W.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+//Flush sends any buffered data to the client
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.W.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/base64"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/vedhavyas/oauth2_central/assertion"
 	"github.com/vedhavyas/oauth2_central/config"
 	"github.com/vedhavyas/oauth2_central/server"
 	"github.com/vedhavyas/oauth2_central/sessions"
@@ -13,6 +19,8 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	configFile := flag.String("config-file", "", "configuration file for the service")
 	showVersion := flag.Bool("version", false, "version deatils of oauth2_central")
+	assertionAudience := flag.String("assertion-secret-for", "",
+		"prints the secret verifying the assertions of the service at the origin, ex: https://app.mydomain.com")
 	flag.Parse()
 
 	if *showVersion {
@@ -24,6 +32,39 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	sessions.InitiateCookieStores()
+
+	if *assertionAudience != "" {
+		printAssertionSecret(*assertionAudience)
+		return
+	}
+
+	err = sessions.InitiateStores()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go reloadOnHangup(*configFile)
 	server.ServeHTTPSIfAvailable()
 }
+
+// reloadOnHangup reloads the configuration and re-initiates the session stores on SIGHUP,
+// so that cookie keys can be rotated without a restart. A failed reload keeps the current configuration
+func reloadOnHangup(configFile string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		err := server.ReloadConfigFile(configFile)
+		if err != nil {
+			log.Println("failed to reload the configuration:", err)
+		}
+	}
+}
+
+// printAssertionSecret prints the base64 encoded secret of the audience, given to the service verifying its assertions
+func printAssertionSecret(audience string) {
+	if config.Config.AssertionSecret == "" {
+		log.Fatal("assertion_secret is not set")
+	}
+
+	secret := assertion.AudienceSecret([]byte(config.Config.AssertionSecret), audience)
+	fmt.Println(base64.StdEncoding.EncodeToString(secret))
+}
@@ -0,0 +1,169 @@
+package providers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// apiProfileTTL is how long a profile fetched from the provider APIs is trusted before it is fetched again
+const apiProfileTTL = 5 * time.Minute
+
+// tokenAuthStyle is how the client credentials are sent to the token endpoint
+type tokenAuthStyle int
+
+const (
+	// authInHeader sends the credentials in the basic auth header, which RFC 6749 requires every authorization server to accept
+	authInHeader tokenAuthStyle = iota
+
+	// authInParams sends the credentials as the client_id and client_secret form params
+	authInParams
+)
+
+// requestToken posts the token request to the token endpoint with the client credentials sent in the authStyle
+func requestToken(tokenURL *url.URL, clientID, clientSecret string, authStyle tokenAuthStyle, params url.Values) (*RedeemResponse, error) {
+	if authStyle == authInParams {
+		params.Set("client_id", clientID)
+		params.Set("client_secret", clientSecret)
+	}
+
+	req, err := http.NewRequest("POST", tokenURL.String(), bytes.NewBufferString(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if authStyle == authInHeader {
+		req.SetBasicAuth(clientID, clientSecret)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %d from %q %s", resp.StatusCode, tokenURL.String(), body)
+	}
+
+	var jsonResponse struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		IDToken      string `json:"id_token"`
+		Error        string `json:"error"`
+	}
+	err = json.Unmarshal(body, &jsonResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// some providers, Slack, report the errors with a 200
+	if jsonResponse.Error != "" || jsonResponse.AccessToken == "" {
+		return nil, fmt.Errorf("token request to %q failed %s", tokenURL.String(), body)
+	}
+
+	redeemResponse := RedeemResponse{}
+	redeemResponse.AccessToken = jsonResponse.AccessToken
+	redeemResponse.RefreshToken = jsonResponse.RefreshToken
+	if jsonResponse.ExpiresIn > 0 {
+		redeemResponse.ExpiresOn = time.Now().Add(time.Duration(jsonResponse.ExpiresIn) * time.Second).Truncate(time.Second)
+	}
+	redeemResponse.IDToken = jsonResponse.IDToken
+	return &redeemResponse, nil
+}
+
+// getJSON fetches the API resource into v using the access token as a bearer token
+func getJSON(resourceURL string, accessToken string, v interface{}) error {
+	req, err := http.NewRequest("GET", resourceURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d from %q %s", resp.StatusCode, resourceURL, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// cachedProfile is a profile trusted until expiresAt
+type cachedProfile struct {
+	authResponse AuthResponse
+	expiresAt    time.Time
+}
+
+// profileCache caches the profiles by the hash of the provider name and the access token
+// so that every request doesn't hit the provider APIs
+type profileCache struct {
+	sync.Mutex
+	entries map[string]cachedProfile
+}
+
+func newProfileCache() *profileCache {
+	return &profileCache{entries: make(map[string]cachedProfile)}
+}
+
+func (cache *profileCache) key(providerName, accessToken string) string {
+	hashed := sha256.Sum256([]byte(providerName + ":" + accessToken))
+	return hex.EncodeToString(hashed[:])
+}
+
+// get returns a copy of the cached profile of the access token if it has not expired
+func (cache *profileCache) get(providerName, accessToken string) (*AuthResponse, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	profile, ok := cache.entries[cache.key(providerName, accessToken)]
+	if !ok || time.Now().After(profile.expiresAt) {
+		return nil, false
+	}
+
+	authResponse := profile.authResponse
+	return &authResponse, true
+}
+
+// put caches the profile of the access token until expiresAt and drops the expired profiles
+func (cache *profileCache) put(providerName, accessToken string, authResponse *AuthResponse, expiresAt time.Time) {
+	cache.Lock()
+	defer cache.Unlock()
+	for k, entry := range cache.entries {
+		if time.Now().After(entry.expiresAt) {
+			delete(cache.entries, k)
+		}
+	}
+	cache.entries[cache.key(providerName, accessToken)] = cachedProfile{authResponse: *authResponse, expiresAt: expiresAt}
+}
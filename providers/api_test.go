@@ -0,0 +1,96 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newRecordedAPI replays the recorded responses, by the request path, to the "valid_token" bearer.
+// The token endpoint at /token redeems "valid_code" and "refresh_token" for the client's basic credentials
+func newRecordedAPI(responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			clientID, clientSecret, _ := r.BasicAuth()
+			if clientID != "client" || clientSecret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if r.Form.Get("code") != "valid_code" && r.Form.Get("refresh_token") != "refresh_token" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+
+			w.Write([]byte(`{"access_token":"valid_token","refresh_token":"rotated_token","expires_in":7200,"token_type":"Bearer"}`))
+			return
+		}
+
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer valid_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+}
+
+func TestRequestToken(t *testing.T) {
+	server := newRecordedAPI(nil)
+	defer server.Close()
+	tokenURL, _ := url.Parse(server.URL + "/token")
+
+	redeemResponse, err := requestToken(tokenURL, "client", "secret", authInHeader, url.Values{"code": {"valid_code"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid_token", redeemResponse.AccessToken)
+	assert.Equal(t, "rotated_token", redeemResponse.RefreshToken)
+	assert.True(t, redeemResponse.ExpiresOn.After(time.Now().Add(time.Hour)))
+
+	_, err = requestToken(tokenURL, "client", "secret", authInHeader, url.Values{"code": {"invalid_code"}})
+	assert.NotNil(t, err)
+
+	_, err = requestToken(tokenURL, "client", "other", authInHeader, url.Values{"code": {"valid_code"}})
+	assert.NotNil(t, err)
+
+	// errors reported with a 200
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":false,"error":"invalid_code"}`))
+	}))
+	defer slack.Close()
+	tokenURL, _ = url.Parse(slack.URL)
+	_, err = requestToken(tokenURL, "client", "secret", authInHeader, url.Values{"code": {"valid_code"}})
+	assert.NotNil(t, err)
+}
+
+func TestProfileCache(t *testing.T) {
+	cache := newProfileCache()
+	cache.put("test", "token", &AuthResponse{Email: "john@example.com"}, time.Now().Add(time.Minute))
+	cache.put("test", "expired", &AuthResponse{Email: "john@example.com"}, time.Now().Add(-time.Minute))
+
+	authResponse, ok := cache.get("test", "token")
+	assert.True(t, ok)
+	assert.Equal(t, "john@example.com", authResponse.Email)
+
+	// the cached profile can't be changed through the copy
+	authResponse.Email = "other@example.com"
+	authResponse, _ = cache.get("test", "token")
+	assert.Equal(t, "john@example.com", authResponse.Email)
+
+	_, ok = cache.get("other", "token")
+	assert.False(t, ok)
+	_, ok = cache.get("test", "expired")
+	assert.False(t, ok)
+}
@@ -0,0 +1,73 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/vedhavyas/oauth2_central/utilities"
+)
+
+//AuthState holds the values of a single login, sent along with the authorization request
+//and needed again to redeem the code on the callback
+type AuthState struct {
+	//State is returned as is by the provider on the callback
+	State string
+	//CodeVerifier is the PKCE secret whose S256 challenge is sent with the authorization request (RFC 7636)
+	CodeVerifier string
+	//Nonce is echoed in the ID token, binding it to the login
+	Nonce string
+}
+
+//NewAuthState returns the AuthState of a new login with a fresh code verifier and nonce
+func NewAuthState(state string) (*AuthState, error) {
+	codeVerifier, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthState{State: state, CodeVerifier: codeVerifier, Nonce: nonce}, nil
+}
+
+//CodeChallenge returns the S256 challenge of the code verifier
+func (authState *AuthState) CodeChallenge() string {
+	hashed := sha256.Sum256([]byte(authState.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hashed[:])
+}
+
+// setAuthParams sets the state, the PKCE challenge and, for the providers issuing ID tokens, the nonce
+func (authState *AuthState) setAuthParams(params url.Values, withNonce bool) {
+	params.Set("state", authState.State)
+	if authState.CodeVerifier != "" {
+		params.Set("code_challenge", authState.CodeChallenge())
+		params.Set("code_challenge_method", "S256")
+	}
+
+	if withNonce && authState.Nonce != "" {
+		params.Set("nonce", authState.Nonce)
+	}
+}
+
+// setRedeemParams sets the PKCE code verifier of the token request
+func (authState *AuthState) setRedeemParams(params url.Values) {
+	if authState.CodeVerifier != "" {
+		params.Set("code_verifier", authState.CodeVerifier)
+	}
+}
+
+// generateRandomToken returns a 43 characters long random token, without padding so that
+// it is a valid code verifier
+func generateRandomToken() (string, error) {
+	token, err := utilities.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(token, "="), nil
+}
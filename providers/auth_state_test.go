@@ -0,0 +1,26 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthState_CodeChallenge(t *testing.T) {
+	// https://tools.ietf.org/html/rfc7636#appendix-B
+	authState := &AuthState{CodeVerifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"}
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", authState.CodeChallenge())
+}
+
+func TestNewAuthState(t *testing.T) {
+	authState, err := NewAuthState("google||token")
+	assert.Nil(t, err)
+	assert.Equal(t, "google||token", authState.State)
+	assert.Len(t, authState.CodeVerifier, 43)
+	assert.NotContains(t, authState.CodeVerifier, "=")
+	assert.NotEmpty(t, authState.Nonce)
+
+	other, _ := NewAuthState("google||token")
+	assert.NotEqual(t, authState.CodeVerifier, other.CodeVerifier)
+	assert.NotEqual(t, authState.Nonce, other.Nonce)
+}
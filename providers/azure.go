@@ -0,0 +1,175 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+const (
+	// azureDefaultBaseURL is the Microsoft identity platform authority
+	azureDefaultBaseURL = "https://login.microsoftonline.com"
+
+	// azureDefaultTenant lets users of any Entra ID tenant and personal Microsoft accounts login
+	azureDefaultTenant = "common"
+
+	// azureProfileTTL caps how long a profile read from an ID token is trusted before the tokens are refreshed
+	azureProfileTTL = time.Hour
+)
+
+// azureMultiTenants are the tenants of the authority accepting users of more than one tenant
+var azureMultiTenants = []string{"common", "organizations", "consumers"}
+
+//AzureProvider for Microsoft Entra ID (Azure AD) Authentication using the v2.0 endpoints.
+//The profile, along with the groups and roles claims, comes from the ID token since the
+//access tokens are meant for Microsoft Graph and can't be introspected. It is returned with
+//the tokens to be kept in the session
+type AzureProvider struct {
+	oidc   *OIDCProvider
+	config config.ProviderConfig
+}
+
+//RedirectToAuthPage redirects to the tenant's authorization endpoint
+func (provider *AzureProvider) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	provider.oidc.RedirectToAuthPage(w, r, authState)
+}
+
+//RedeemCode gets access token, refresh token and ID token using the code provided.
+//The ID token is verified and its profile returned along with the tokens
+func (provider *AzureProvider) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	redeemResponse, err := provider.oidc.RedeemCode(code, redirectURL, authState)
+	if err != nil {
+		return nil, err
+	}
+
+	err = provider.setProfile(redeemResponse, authState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return redeemResponse, nil
+}
+
+//RefreshAccessToken fetch new access token using the offline refresh token.
+//The ID token issued along with it is verified again so that the tenant and claims are current
+func (provider *AzureProvider) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	redeemResponse, err := provider.oidc.RefreshAccessToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = provider.setProfile(redeemResponse, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return redeemResponse, nil
+}
+
+//GetProfileDataFromAccessToken always fails since the profile can only be read from the ID token.
+//The profile returned with the tokens is kept in the session instead, and the tokens are refreshed once it expires
+func (provider *AzureProvider) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	return nil, errors.New("profile is only read from the ID token")
+}
+
+//ProfileFromIDTokenClaims checks the tenant of the ID token and maps the Entra ID claims to the profile
+func (provider *AzureProvider) ProfileFromIDTokenClaims(claims *IDTokenClaims, authResponse *AuthResponse) error {
+	if !provider.tenantAllowed(claims.TenantID) {
+		return newIDTokenError(ErrCodeInvalidTenant, "tenant %q is not allowed", claims.TenantID)
+	}
+
+	authResponse.Login = claims.PreferredName
+	if authResponse.Email == "" {
+		authResponse.Email = claims.PreferredName
+	}
+	if authResponse.Name == "" {
+		authResponse.Name = claims.PreferredName
+	}
+	authResponse.Groups = claims.Groups
+	authResponse.Roles = claims.Roles
+	return nil
+}
+
+//Data provides provider specific data
+func (provider *AzureProvider) Data() *ProviderData {
+	return provider.oidc.Data()
+}
+
+// setProfile verifies the ID token of the response and sets its profile, trusted until the access token expires
+func (provider *AzureProvider) setProfile(redeemResponse *RedeemResponse, nonce string) error {
+	if redeemResponse.IDToken == "" {
+		return errors.New("ID token missing in the token response, openid scope is required")
+	}
+
+	authResponse := AuthResponse{}
+	err := GetProfileFromIDToken(provider, &authResponse, redeemResponse.IDToken, nonce)
+	if err != nil {
+		return err
+	}
+
+	expiresOn := time.Now().Add(azureProfileTTL).Truncate(time.Second)
+	if redeemResponse.ExpiresOn.IsZero() || redeemResponse.ExpiresOn.After(expiresOn) {
+		redeemResponse.ExpiresOn = expiresOn
+	}
+
+	redeemResponse.Profile = &authResponse
+	return nil
+}
+
+// tenantAllowed checks the tid claim against the configured tenant. A single tenant must match the tid
+// while multi tenant authorities accept any tenant unless allowed_tenants lists them
+func (provider *AzureProvider) tenantAllowed(tenantID string) bool {
+	if tenantID == "" {
+		return false
+	}
+
+	tenant := provider.tenant()
+	if !containsFold(azureMultiTenants, tenant) {
+		return strings.EqualFold(tenant, tenantID)
+	}
+
+	if len(provider.config.AllowedTenants) == 0 {
+		return true
+	}
+
+	return containsFold(provider.config.AllowedTenants, tenantID)
+}
+
+func (provider *AzureProvider) tenant() string {
+	if provider.config.Tenant == "" {
+		return azureDefaultTenant
+	}
+	return provider.config.Tenant
+}
+
+//NewAzureProvider gives new Microsoft Entra ID provider for the given configuration.
+//base_url overrides the authority for the national clouds
+func NewAzureProvider(providerConfig config.ProviderConfig) Provider {
+	if providerConfig.AuthScope == "" {
+		providerConfig.AuthScope = "openid profile email offline_access"
+	}
+
+	baseURL := strings.TrimSuffix(providerConfig.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = azureDefaultBaseURL
+	}
+
+	provider := &AzureProvider{config: providerConfig}
+	tenantURL := fmt.Sprintf("%s/%s", baseURL, url.PathEscape(provider.tenant()))
+
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	pData.ClientID = providerConfig.ClientID
+	pData.LoginURL, _ = url.Parse(tenantURL + "/oauth2/v2.0/authorize")
+	pData.RedeemURL, _ = url.Parse(tenantURL + "/oauth2/v2.0/token")
+	pData.JWKSURL, _ = url.Parse(tenantURL + "/discovery/v2.0/keys")
+	pData.Issuer = baseURL + "/{tenantid}/v2.0"
+
+	provider.oidc = &OIDCProvider{pData: &pData, config: providerConfig}
+	return provider
+}
@@ -0,0 +1,204 @@
+package providers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+const (
+	testTenant      = "9f4c2b1e-0a7d-4c3b-8e55-1d2f3a4b5c6d"
+	testOtherTenant = "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0"
+)
+
+// fakeAzure serves the v2.0 token and keys endpoints of any tenant, issuing ID tokens for the tenant in tid
+type fakeAzure struct {
+	*httptest.Server
+	signer *testSigner
+	tid    string
+	issuer string
+}
+
+func newFakeAzure(t *testing.T) *fakeAzure {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	azure := &fakeAzure{signer: &testSigner{kid: "azure", rsaKey: key, enabled: true}, tid: testTenant}
+	jwks := &fakeJWKS{signers: []*testSigner{azure.signer}}
+	azure.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "keys":
+			jwks.ServeHTTP(w, r)
+		case "token":
+			azure.token(t, w, r)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return azure
+}
+
+func (azure *fakeAzure) token(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("client_id") != "client" || r.Form.Get("client_secret") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	issuer := azure.issuer
+	if issuer == "" {
+		issuer = azure.URL + "/" + azure.tid + "/v2.0"
+	}
+
+	claims := map[string]interface{}{
+		"iss":                issuer,
+		"aud":                "client",
+		"sub":                "1234",
+		"tid":                azure.tid,
+		"name":               "John",
+		"preferred_username": "john@contoso.com",
+		"groups":             []string{"a1b2c3"},
+		"roles":              []string{"Reader"},
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+
+	accessToken := "refreshed_token"
+	if r.Form.Get("grant_type") == "authorization_code" {
+		accessToken = "valid_token"
+		claims["nonce"] = "valid_nonce"
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": "refresh_token",
+		"expires_in":    3600,
+		"id_token":      azure.signer.sign(t, claims),
+	})
+}
+
+func TestAzureProvider_RedirectToAuthPage(t *testing.T) {
+	provider := NewAzureProvider(config.ProviderConfig{Name: "azure", ClientID: "client", Tenant: testTenant})
+	r := httptest.NewRequest("GET", "http://sso.example.com/oauth2/start", nil)
+	w := httptest.NewRecorder()
+	authState, _ := NewAuthState("state")
+	provider.RedirectToAuthPage(w, r, authState)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "login.microsoftonline.com", location.Host)
+	assert.Equal(t, "/"+testTenant+"/oauth2/v2.0/authorize", location.Path)
+	assert.Equal(t, "openid profile email offline_access", location.Query().Get("scope"))
+	assert.NotEmpty(t, location.Query().Get("nonce"))
+}
+
+func TestAzureProvider_Tenants(t *testing.T) {
+	azure := newFakeAzure(t)
+	defer azure.Close()
+
+	tests := []struct {
+		tenant         string
+		allowedTenants []string
+		tid            string
+		issuer         string
+		expectedCode   string
+	}{
+		// single tenant
+		{tenant: testTenant, tid: testTenant},
+		{tenant: testTenant, tid: testOtherTenant, expectedCode: ErrCodeInvalidTenant},
+
+		// multi tenant
+		{tid: testTenant},
+		{tenant: "organizations", tid: testOtherTenant},
+		{tid: "", expectedCode: ErrCodeInvalidTenant},
+
+		// allowed tenant list
+		{allowedTenants: []string{testTenant}, tid: testTenant},
+		{allowedTenants: []string{testTenant}, tid: testOtherTenant, expectedCode: ErrCodeInvalidTenant},
+
+		// the issuer must belong to the tenant of the token
+		{tid: testTenant, issuer: azure.URL + "/" + testOtherTenant + "/v2.0", expectedCode: ErrCodeInvalidIssuer},
+	}
+
+	for _, test := range tests {
+		azure.tid, azure.issuer = test.tid, test.issuer
+		provider := NewAzureProvider(config.ProviderConfig{
+			Name:           "azure",
+			BaseURL:        azure.URL,
+			ClientID:       "client",
+			ClientSecret:   "secret",
+			Tenant:         test.tenant,
+			AllowedTenants: test.allowedTenants,
+		})
+
+		_, err := provider.RedeemCode("valid_code", "http://sso.example.com/oauth2/callback", &AuthState{Nonce: "valid_nonce"})
+		if test.expectedCode == "" {
+			assert.Nil(t, err, test.tid)
+			continue
+		}
+
+		tokenErr, ok := err.(*IDTokenError)
+		if assert.True(t, ok, test.tid) {
+			assert.Equal(t, test.expectedCode, tokenErr.Code)
+		}
+	}
+}
+
+func TestAzureProvider_Profile(t *testing.T) {
+	azure := newFakeAzure(t)
+	defer azure.Close()
+
+	provider := NewAzureProvider(config.ProviderConfig{
+		Name:         "azure-test",
+		BaseURL:      azure.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Tenant:       testTenant,
+	})
+
+	// the nonce of the login is checked
+	_, err := provider.RedeemCode("valid_code", "http://sso.example.com/oauth2/callback", &AuthState{Nonce: "other_nonce"})
+	assert.NotNil(t, err)
+
+	redeemResponse, err := provider.RedeemCode("valid_code", "http://sso.example.com/oauth2/callback", &AuthState{Nonce: "valid_nonce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authResponse := &AuthResponse{}
+	assert.Nil(t, GetProfileFromIDToken(provider, authResponse, redeemResponse.IDToken, "valid_nonce"))
+	assert.Equal(t, "john@contoso.com", authResponse.Email)
+	assert.Equal(t, "john@contoso.com", authResponse.Login)
+	assert.Equal(t, []string{"a1b2c3"}, authResponse.Groups)
+	assert.Equal(t, []string{"Reader"}, authResponse.Roles)
+
+	assert.Equal(t, authResponse, redeemResponse.Profile)
+	assert.False(t, redeemResponse.ExpiresOn.After(time.Now().Add(azureProfileTTL)))
+
+	// the profile is only read from the ID token
+	_, err = provider.GetProfileDataFromAccessToken("valid_token")
+	assert.NotNil(t, err)
+
+	// refreshed tokens carry a new ID token with the current claims
+	redeemResponse, err = provider.RefreshAccessToken("refresh_token")
+	assert.Nil(t, err)
+	assert.Equal(t, "refreshed_token", redeemResponse.AccessToken)
+	if assert.NotNil(t, redeemResponse.Profile) {
+		assert.Equal(t, []string{"Reader"}, redeemResponse.Profile.Roles)
+	}
+}
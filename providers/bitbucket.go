@@ -0,0 +1,233 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+// bitbucketMaxPages bounds the pages fetched for a single listing
+const bitbucketMaxPages = 10
+
+var (
+	//ErrNoBitbucketEmail is returned when the Bitbucket user has no confirmed email
+	ErrNoBitbucketEmail = errors.New("bitbucket account has no confirmed email")
+
+	//ErrNotBitbucketMember is returned when the Bitbucket user is not a member of any of the allowed workspaces
+	ErrNotBitbucketMember = errors.New("not a member of the allowed Bitbucket workspaces")
+)
+
+// bitbucketProfiles caches the profiles, along with the workspaces, by the access token
+var bitbucketProfiles = newProfileCache()
+
+//Bitbucket for Bitbucket Cloud Authentication
+type Bitbucket struct {
+	pData  *ProviderData
+	apiURL *url.URL
+	config config.ProviderConfig
+}
+
+//RedirectToAuthPage redirects to Bitbucket Auth page
+func (provider *Bitbucket) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	authURL := *provider.pData.LoginURL
+	params, _ := url.ParseQuery(authURL.RawQuery)
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.config.ClientID)
+	params.Set("redirect_uri", GetAuthCallBackURL(r))
+	params.Set("state", authState.State)
+	authURL.RawQuery = params.Encode()
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+//RefreshAccessToken fetch new access token using the offline refresh token
+func (provider *Bitbucket) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("refresh_token", refreshToken)
+	params.Set("grant_type", "refresh_token")
+
+	redeemResponse, err := requestToken(provider.pData.RedeemURL, provider.config.ClientID, provider.config.ClientSecret, authInHeader, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if redeemResponse.RefreshToken == "" {
+		redeemResponse.RefreshToken = refreshToken
+	}
+	return redeemResponse, nil
+}
+
+//RedeemCode gets access token and refresh token using the code provided
+func (provider *Bitbucket) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("redirect_uri", redirectURL)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+	return requestToken(provider.pData.RedeemURL, provider.config.ClientID, provider.config.ClientSecret, authInHeader, params)
+}
+
+//GetProfileDataFromAccessToken gets user profile from access token.
+//The workspaces of the user are returned as groups once the membership is checked
+func (provider *Bitbucket) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	if authResponse, ok := bitbucketProfiles.get(provider.pData.ProviderName, accessToken); ok {
+		return authResponse, nil
+	}
+
+	var jsonResponse struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+		Links       struct {
+			Avatar struct {
+				Href string `json:"href"`
+			} `json:"avatar"`
+		} `json:"links"`
+	}
+	err := getJSON(provider.pData.ValidateURL.String(), accessToken, &jsonResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := provider.getConfirmedEmail(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	workspaces, err := provider.getWorkspaces(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(provider.config.Workspaces) > 0 && !containsAnyFold(provider.config.Workspaces, workspaces) {
+		return nil, ErrNotBitbucketMember
+	}
+
+	authResponse := AuthResponse{}
+	authResponse.Email = email
+	authResponse.EmailVerified = true
+	authResponse.Name = jsonResponse.DisplayName
+	authResponse.Login = jsonResponse.Username
+	authResponse.Picture = jsonResponse.Links.Avatar.Href
+	authResponse.Groups = workspaces
+
+	bitbucketProfiles.put(provider.pData.ProviderName, accessToken, &authResponse, time.Now().Add(apiProfileTTL))
+	return &authResponse, nil
+}
+
+// getConfirmedEmail returns the primary email of the user if it is confirmed, else any other confirmed email
+func (provider *Bitbucket) getConfirmedEmail(accessToken string) (string, error) {
+	var emails []struct {
+		Email       string `json:"email"`
+		IsPrimary   bool   `json:"is_primary"`
+		IsConfirmed bool   `json:"is_confirmed"`
+	}
+	err := provider.getAllValues(accessToken, "/user/emails", &emails)
+	if err != nil {
+		return "", err
+	}
+
+	confirmed := ""
+	for _, email := range emails {
+		if !email.IsConfirmed {
+			continue
+		}
+
+		if email.IsPrimary {
+			return email.Email, nil
+		}
+
+		if confirmed == "" {
+			confirmed = email.Email
+		}
+	}
+
+	if confirmed == "" {
+		return "", ErrNoBitbucketEmail
+	}
+
+	return confirmed, nil
+}
+
+// getWorkspaces returns the slugs of the workspaces the user is a member of
+func (provider *Bitbucket) getWorkspaces(accessToken string) ([]string, error) {
+	var permissions []struct {
+		Workspace struct {
+			Slug string `json:"slug"`
+		} `json:"workspace"`
+	}
+	err := provider.getAllValues(accessToken, "/user/permissions/workspaces", &permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	var workspaces []string
+	for _, permission := range permissions {
+		workspaces = append(workspaces, permission.Workspace.Slug)
+	}
+
+	return workspaces, nil
+}
+
+// getAllValues follows the next links of the paginated Bitbucket listing and collects the values into v,
+// a pointer to a slice
+func (provider *Bitbucket) getAllValues(accessToken string, path string, v interface{}) error {
+	var all []json.RawMessage
+	next := provider.apiURL.String() + path
+	for page := 0; next != "" && page < bitbucketMaxPages; page++ {
+		var jsonResponse struct {
+			Values []json.RawMessage `json:"values"`
+			Next   string            `json:"next"`
+		}
+		err := getJSON(next, accessToken, &jsonResponse)
+		if err != nil {
+			return err
+		}
+
+		all = append(all, jsonResponse.Values...)
+		next = jsonResponse.Next
+	}
+
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+//Data provides provider specific data
+func (provider *Bitbucket) Data() *ProviderData {
+	return provider.pData
+}
+
+//NewBitbucketProvider gives new Bitbucket Cloud provider for the named provider.
+//The scopes are those of the OAuth consumer, which needs the account and email permissions
+func NewBitbucketProvider(providerConfig config.ProviderConfig) Provider {
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	pData.ClientID = providerConfig.ClientID
+	pData.LoginURL = &url.URL{Scheme: "https",
+		Host: "bitbucket.org",
+		Path: "/site/oauth2/authorize"}
+	pData.RedeemURL = &url.URL{Scheme: "https",
+		Host: "bitbucket.org",
+		Path: "/site/oauth2/access_token"}
+	pData.ValidateURL = &url.URL{Scheme: "https",
+		Host: "api.bitbucket.org",
+		Path: "/2.0/user"}
+
+	apiURL := &url.URL{Scheme: "https", Host: "api.bitbucket.org", Path: "/2.0"}
+	return &Bitbucket{pData: &pData, apiURL: apiURL, config: providerConfig}
+}
+
+// containsAnyFold reports whether the values contain any of the others, ignoring the case
+func containsAnyFold(values []string, others []string) bool {
+	for _, other := range others {
+		if containsFold(values, other) {
+			return true
+		}
+	}
+	return false
+}
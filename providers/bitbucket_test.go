@@ -0,0 +1,110 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+// recorded Bitbucket Cloud responses, trimmed to the fields read
+const (
+	bitbucketUser = `{
+		"type": "user",
+		"uuid": "{c7a3f1e2-5b4d-4e6f-8a9b-0c1d2e3f4a5b}",
+		"username": "jdoe",
+		"display_name": "John Doe",
+		"account_id": "557058:1f2e3d4c",
+		"links": {"avatar": {"href": "https://avatar-management.services.atlassian.com/jdoe/128"}}
+	}`
+	bitbucketEmailsPage1 = `{
+		"pagelen": 1,
+		"page": 1,
+		"values": [{"type": "email", "email": "old@example.com", "is_primary": false, "is_confirmed": true}],
+		"next": "%s/2.0/user/emails/page/2"
+	}`
+	bitbucketEmailsPage2 = `{
+		"pagelen": 1,
+		"page": 2,
+		"values": [{"type": "email", "email": "jdoe@example.com", "is_primary": true, "is_confirmed": true}]
+	}`
+	bitbucketWorkspaces = `{
+		"pagelen": 10,
+		"values": [
+			{"type": "workspace_membership", "permission": "member", "workspace": {"type": "workspace", "slug": "acme", "name": "Acme"}},
+			{"type": "workspace_membership", "permission": "owner", "workspace": {"type": "workspace", "slug": "jdoe", "name": "John Doe"}}
+		]
+	}`
+)
+
+func newTestBitbucketProvider(apiURL string, workspaces []string) *Bitbucket {
+	provider := NewBitbucketProvider(config.ProviderConfig{
+		Name:         "bitbucket",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Workspaces:   workspaces,
+	}).(*Bitbucket)
+	provider.apiURL, _ = url.Parse(apiURL + "/2.0")
+	provider.pData.RedeemURL, _ = url.Parse(apiURL + "/token")
+	provider.pData.ValidateURL, _ = url.Parse(apiURL + "/2.0/user")
+	return provider
+}
+
+func TestBitbucket_GetProfileDataFromAccessToken(t *testing.T) {
+	responses := map[string]string{
+		"/2.0/user":                        bitbucketUser,
+		"/2.0/user/emails/page/2":          bitbucketEmailsPage2,
+		"/2.0/user/permissions/workspaces": bitbucketWorkspaces,
+	}
+	server := newRecordedAPI(responses)
+	defer server.Close()
+	responses["/2.0/user/emails"] = fmt.Sprintf(bitbucketEmailsPage1, server.URL)
+
+	tests := []struct {
+		workspaces  []string
+		expectError bool
+	}{
+		{},
+		{workspaces: []string{"ACME"}},
+		{workspaces: []string{"other", "acme"}},
+		{workspaces: []string{"other"}, expectError: true},
+	}
+
+	for _, test := range tests {
+		provider := newTestBitbucketProvider(server.URL, test.workspaces)
+		bitbucketProfiles = newProfileCache()
+
+		authResponse, err := provider.GetProfileDataFromAccessToken("valid_token")
+		if test.expectError {
+			assert.Equal(t, ErrNotBitbucketMember, err)
+			continue
+		}
+
+		if assert.Nil(t, err) {
+			assert.Equal(t, "jdoe@example.com", authResponse.Email)
+			assert.True(t, authResponse.EmailVerified)
+			assert.Equal(t, "John Doe", authResponse.Name)
+			assert.Equal(t, "jdoe", authResponse.Login)
+			assert.Equal(t, []string{"acme", "jdoe"}, authResponse.Groups)
+		}
+	}
+
+	_, err := newTestBitbucketProvider(server.URL, nil).GetProfileDataFromAccessToken("invalid_token")
+	assert.NotNil(t, err)
+}
+
+func TestBitbucket_RedeemAndRefresh(t *testing.T) {
+	server := newRecordedAPI(nil)
+	defer server.Close()
+	provider := newTestBitbucketProvider(server.URL, nil)
+
+	redeemResponse, err := provider.RedeemCode("valid_code", "http://sso.example.com/oauth2/callback", &AuthState{State: "state"})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid_token", redeemResponse.AccessToken)
+
+	redeemResponse, err = provider.RefreshAccessToken("refresh_token")
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated_token", redeemResponse.RefreshToken)
+}
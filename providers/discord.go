@@ -0,0 +1,184 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+const (
+	// discordGuildsPageSize is the largest page of the guild listing
+	discordGuildsPageSize = 200
+
+	// discordMaxGuildPages bounds the pages fetched for the guild listing
+	discordMaxGuildPages = 10
+)
+
+//ErrNotDiscordGuildMember is returned when the Discord user is not a member of any of the allowed guilds
+var ErrNotDiscordGuildMember = errors.New("not a member of the allowed Discord guilds")
+
+// discordProfiles caches the profiles, along with the guilds, by the access token.
+// Discord rate limits the guild listing, so it is not fetched on every request
+var discordProfiles = newProfileCache()
+
+//Discord for Discord Authentication
+type Discord struct {
+	pData  *ProviderData
+	apiURL *url.URL
+	config config.ProviderConfig
+}
+
+//RedirectToAuthPage redirects to Discord Auth page
+func (provider *Discord) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	authURL := *provider.pData.LoginURL
+	params, _ := url.ParseQuery(authURL.RawQuery)
+	params.Set("response_type", "code")
+	params.Set("scope", provider.config.AuthScope)
+	params.Set("client_id", provider.config.ClientID)
+	params.Set("redirect_uri", GetAuthCallBackURL(r))
+	params.Set("prompt", "none")
+	authState.setAuthParams(params, false)
+	authURL.RawQuery = params.Encode()
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+//RefreshAccessToken fetch new access token using the offline refresh token
+func (provider *Discord) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("refresh_token", refreshToken)
+	params.Set("grant_type", "refresh_token")
+
+	redeemResponse, err := requestToken(provider.pData.RedeemURL, provider.config.ClientID, provider.config.ClientSecret, authInHeader, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if redeemResponse.RefreshToken == "" {
+		redeemResponse.RefreshToken = refreshToken
+	}
+	return redeemResponse, nil
+}
+
+//RedeemCode gets access token and refresh token using the code provided
+func (provider *Discord) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("redirect_uri", redirectURL)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+	authState.setRedeemParams(params)
+	return requestToken(provider.pData.RedeemURL, provider.config.ClientID, provider.config.ClientSecret, authInHeader, params)
+}
+
+//GetProfileDataFromAccessToken gets user profile from access token after checking
+//the user is a member of the allowed guilds
+func (provider *Discord) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	if authResponse, ok := discordProfiles.get(provider.pData.ProviderName, accessToken); ok {
+		return authResponse, nil
+	}
+
+	var jsonResponse struct {
+		ID         string `json:"id"`
+		Username   string `json:"username"`
+		GlobalName string `json:"global_name"`
+		Email      string `json:"email"`
+		Verified   bool   `json:"verified"`
+		Avatar     string `json:"avatar"`
+	}
+	err := getJSON(provider.pData.ValidateURL.String(), accessToken, &jsonResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(provider.config.Guilds) > 0 {
+		member, err := provider.isGuildMember(accessToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if !member {
+			return nil, ErrNotDiscordGuildMember
+		}
+	}
+
+	authResponse := AuthResponse{}
+	authResponse.Email = jsonResponse.Email
+	authResponse.EmailVerified = jsonResponse.Verified
+	authResponse.Name = jsonResponse.GlobalName
+	authResponse.Login = jsonResponse.Username
+	if authResponse.Name == "" {
+		authResponse.Name = jsonResponse.Username
+	}
+	if jsonResponse.Avatar != "" {
+		authResponse.Picture = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", jsonResponse.ID, jsonResponse.Avatar)
+	}
+
+	discordProfiles.put(provider.pData.ProviderName, accessToken, &authResponse, time.Now().Add(apiProfileTTL))
+	return &authResponse, nil
+}
+
+// isGuildMember checks whether the user is a member of any of the allowed guilds, which needs the guilds scope.
+// The guilds are listed a page at a time, each page starting after the last guild of the previous one
+func (provider *Discord) isGuildMember(accessToken string) (bool, error) {
+	after := ""
+	for page := 0; page < discordMaxGuildPages; page++ {
+		params := url.Values{"limit": {strconv.Itoa(discordGuildsPageSize)}}
+		if after != "" {
+			params.Set("after", after)
+		}
+
+		var guilds []struct {
+			ID string `json:"id"`
+		}
+		err := getJSON(provider.apiURL.String()+"/users/@me/guilds?"+params.Encode(), accessToken, &guilds)
+		if err != nil {
+			return false, err
+		}
+
+		for _, guild := range guilds {
+			if containsFold(provider.config.Guilds, guild.ID) {
+				return true, nil
+			}
+		}
+
+		if len(guilds) < discordGuildsPageSize {
+			break
+		}
+		after = guilds[len(guilds)-1].ID
+	}
+
+	return false, nil
+}
+
+//Data provides provider specific data
+func (provider *Discord) Data() *ProviderData {
+	return provider.pData
+}
+
+//NewDiscordProvider gives new Discord provider for the named provider
+func NewDiscordProvider(providerConfig config.ProviderConfig) Provider {
+	if providerConfig.AuthScope == "" {
+		providerConfig.AuthScope = "identify email guilds"
+	}
+
+	apiURL := &url.URL{Scheme: "https", Host: "discord.com", Path: "/api/v10"}
+
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	pData.ClientID = providerConfig.ClientID
+	pData.LoginURL = &url.URL{Scheme: "https",
+		Host: "discord.com",
+		Path: "/oauth2/authorize"}
+	pData.RedeemURL = &url.URL{Scheme: apiURL.Scheme,
+		Host: apiURL.Host,
+		Path: apiURL.Path + "/oauth2/token"}
+	pData.ValidateURL = &url.URL{Scheme: apiURL.Scheme,
+		Host: apiURL.Host,
+		Path: apiURL.Path + "/users/@me"}
+
+	return &Discord{pData: &pData, apiURL: apiURL, config: providerConfig}
+}
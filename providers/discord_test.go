@@ -0,0 +1,138 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+// recorded Discord API responses
+const (
+	discordUser = `{
+		"id": "80351110224678912",
+		"username": "nelly",
+		"global_name": "Nelly",
+		"discriminator": "0",
+		"avatar": "8342729096ea3675442027381ff50dfe",
+		"verified": true,
+		"email": "nelly@discord.com",
+		"flags": 64,
+		"premium_type": 1,
+		"public_flags": 64
+	}`
+	discordGuilds = `[
+		{"id": "80351110224678912", "name": "1337 Krew", "icon": "8342729096ea3675442027381ff50dfe", "owner": true, "permissions": "36953089"},
+		{"id": "197038439483310086", "name": "Discord Testers", "icon": null, "owner": false, "permissions": "104189505"}
+	]`
+)
+
+func newTestDiscordProvider(apiURL string, guilds []string) *Discord {
+	provider := NewDiscordProvider(config.ProviderConfig{
+		Name:         "discord",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Guilds:       guilds,
+	}).(*Discord)
+	provider.apiURL, _ = url.Parse(apiURL + "/api/v10")
+	provider.pData.RedeemURL, _ = url.Parse(apiURL + "/token")
+	provider.pData.ValidateURL, _ = url.Parse(apiURL + "/api/v10/users/@me")
+	return provider
+}
+
+func TestDiscord_GetProfileDataFromAccessToken(t *testing.T) {
+	server := newRecordedAPI(map[string]string{
+		"/api/v10/users/@me":        discordUser,
+		"/api/v10/users/@me/guilds": discordGuilds,
+	})
+	defer server.Close()
+
+	tests := []struct {
+		guilds      []string
+		expectError bool
+	}{
+		{},
+		{guilds: []string{"197038439483310086"}},
+		{guilds: []string{"1", "80351110224678912"}},
+		{guilds: []string{"1"}, expectError: true},
+	}
+
+	for _, test := range tests {
+		provider := newTestDiscordProvider(server.URL, test.guilds)
+		discordProfiles = newProfileCache()
+
+		authResponse, err := provider.GetProfileDataFromAccessToken("valid_token")
+		if test.expectError {
+			assert.Equal(t, ErrNotDiscordGuildMember, err)
+			continue
+		}
+
+		if assert.Nil(t, err) {
+			assert.Equal(t, "nelly@discord.com", authResponse.Email)
+			assert.True(t, authResponse.EmailVerified)
+			assert.Equal(t, "Nelly", authResponse.Name)
+			assert.Equal(t, "nelly", authResponse.Login)
+			assert.Equal(t, "https://cdn.discordapp.com/avatars/80351110224678912/8342729096ea3675442027381ff50dfe.png", authResponse.Picture)
+		}
+	}
+
+	_, err := newTestDiscordProvider(server.URL, nil).GetProfileDataFromAccessToken("invalid_token")
+	assert.NotNil(t, err)
+}
+
+func TestDiscord_isGuildMember(t *testing.T) {
+	// the user is a member of the guilds 1 to 450, listed by the ascending IDs after the given one
+	var pages int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pages, 1)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		guilds := []map[string]string{}
+		for id := after + 1; id <= 450 && len(guilds) < limit; id++ {
+			guilds = append(guilds, map[string]string{"id": strconv.Itoa(id)})
+		}
+		json.NewEncoder(w).Encode(guilds)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		guilds []string
+		member bool
+		pages  int32
+	}{
+		{guilds: []string{"150"}, member: true, pages: 1},
+		{guilds: []string{"420"}, member: true, pages: 3},
+		{guilds: []string{"451"}, pages: 3},
+	}
+
+	for _, test := range tests {
+		atomic.StoreInt32(&pages, 0)
+		member, err := newTestDiscordProvider(server.URL, test.guilds).isGuildMember("valid_token")
+		assert.Nil(t, err)
+		assert.Equal(t, test.member, member, test.guilds[0])
+		assert.Equal(t, test.pages, atomic.LoadInt32(&pages), test.guilds[0])
+	}
+}
+
+func TestDiscord_RedeemAndRefresh(t *testing.T) {
+	server := newRecordedAPI(nil)
+	defer server.Close()
+	provider := newTestDiscordProvider(server.URL, nil)
+
+	redeemResponse, err := provider.RedeemCode("valid_code", "http://sso.example.com/oauth2/callback", &AuthState{State: "state"})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid_token", redeemResponse.AccessToken)
+
+	redeemResponse, err = provider.RefreshAccessToken("refresh_token")
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated_token", redeemResponse.RefreshToken)
+
+	_, err = provider.RefreshAccessToken("invalid_token")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,43 @@
+package providers
+
+import "sync"
+
+// fetchGroup runs a single fetch per key at a time. The callers asking for a key being fetched wait for
+// that fetch and share its outcome, while the fetches of the other keys go ahead
+type fetchGroup struct {
+	sync.Mutex
+	calls map[string]*fetchCall
+}
+
+// fetchCall is a fetch in flight
+type fetchCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do runs the fetch of the key unless one is in flight already, in which case its outcome is returned
+func (group *fetchGroup) do(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	group.Lock()
+	if group.calls == nil {
+		group.calls = make(map[string]*fetchCall)
+	}
+
+	if call, ok := group.calls[key]; ok {
+		group.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+
+	call := &fetchCall{done: make(chan struct{})}
+	group.calls[key] = call
+	group.Unlock()
+
+	call.value, call.err = fetch()
+
+	group.Lock()
+	delete(group.calls, key)
+	group.Unlock()
+	close(call.done)
+	return call.value, call.err
+}
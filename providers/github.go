@@ -10,24 +10,30 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/vedhavyas/oauth2_central/config"
 )
 
-//Github for Github Authentication
+//ErrNoVerifiedEmail is returned when the primary email of the GitHub user is not verified
+var ErrNoVerifiedEmail = errors.New("github account's primary email is not verified")
+
+//Github for Github and Github Enterprise Server Authentication
 type Github struct {
-	pData *ProviderData
+	pData  *ProviderData
+	apiURL *url.URL
+	config config.ProviderConfig
 }
 
 //RedirectToAuthPage redirects to Github Auth page
-func (provider *Github) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, state string) {
+func (provider *Github) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
 	authURL := provider.pData.LoginURL
 	params, _ := url.ParseQuery(authURL.RawQuery)
-	params.Set("scope", config.Config.GithubAuthScope)
-	params.Set("client_id", config.Config.GithubClientID)
+	params.Set("scope", provider.config.AuthScope)
+	params.Set("client_id", provider.config.ClientID)
 	params.Set("redirect_uri", GetAuthCallBackURL(r))
-	params.Set("state", state)
-	params.Set("allow_signup", strconv.FormatBool(config.Config.GithubAllowSignUp))
+	authState.setAuthParams(params, false)
+	params.Set("allow_signup", strconv.FormatBool(provider.config.AllowSignUp))
 	authURL.RawQuery = params.Encode()
 	http.Redirect(w, r, authURL.String(), http.StatusFound)
 }
@@ -39,13 +45,14 @@ func (provider *Github) RefreshAccessToken(refreshToken string) (*RedeemResponse
 }
 
 //RedeemCode gets access token and refresh token using the code provided
-func (provider *Github) RedeemCode(code string, redirectURL string, state string) (*RedeemResponse, error) {
+func (provider *Github) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
 	params := url.Values{}
 	params.Add("redirect_uri", redirectURL)
-	params.Add("client_id", config.Config.GithubClientID)
-	params.Add("client_secret", config.Config.GithubClientSecret)
+	params.Add("client_id", provider.config.ClientID)
+	params.Add("client_secret", provider.config.ClientSecret)
 	params.Add("code", code)
-	params.Add("state", state)
+	params.Add("state", authState.State)
+	authState.setRedeemParams(params)
 
 	var req *http.Request
 	req, err := http.NewRequest("POST", provider.pData.RedeemURL.String(), bytes.NewBufferString(params.Encode()))
@@ -55,7 +62,7 @@ func (provider *Github) RedeemCode(code string, redirectURL string, state string
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -88,57 +95,106 @@ func (provider *Github) RedeemCode(code string, redirectURL string, state string
 	return &redeemResponse, nil
 }
 
-//GetProfileDataFromAccessToken gets user profile from access token
+//GetProfileDataFromAccessToken gets user profile from access token.
+//The teams of the user are returned as groups, "org/team-slug", once the membership is checked
 func (provider *Github) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
 	if provider.pData.ValidateURL == nil {
 		return nil, errors.New("Validation URL missing in provider")
 	}
 
-	validateURL := provider.pData.ValidateURL
-	params := url.Values{}
-	params.Set("access_token", accessToken)
-	validateURL.RawQuery = params.Encode()
+	var jsonResponse struct {
+		Email     string `json:"email"`
+		Name      string `json:"name"`
+		Login     string `json:"login"`
+		AvatarURL string `json:"avatar_url"`
+	}
 
-	req, err := http.NewRequest("GET", validateURL.String(), nil)
+	err := provider.apiGet(accessToken, provider.pData.ValidateURL, &jsonResponse)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	email, err := provider.getPrimaryEmail(accessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	groups, err := provider.checkMembership(accessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	err = resp.Body.Close()
+	authResponse := AuthResponse{}
+	authResponse.Email = email.Email
+	authResponse.EmailVerified = email.Verified
+	authResponse.Name = jsonResponse.Name
+	authResponse.Login = jsonResponse.Login
+	authResponse.Picture = jsonResponse.AvatarURL
+	authResponse.Groups = groups
+
+	return &authResponse, nil
+}
+
+// githubEmail is an email address of the GitHub user
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// getPrimaryEmail returns the primary email of the user, failing with ErrNoVerifiedEmail if it is not verified.
+// The other verified emails are never used so that the identity is the one the user chose on GitHub.
+// /user leaves out the private emails, so they are listed with /user/emails which needs the user:email scope
+func (provider *Github) getPrimaryEmail(accessToken string) (*githubEmail, error) {
+	var emails []githubEmail
+	err := provider.apiGetAll(accessToken, "/user/emails", &emails)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("Validate token failed")
+	for i := range emails {
+		if !emails[i].Primary {
+			continue
+		}
+
+		if !emails[i].Verified {
+			return nil, ErrNoVerifiedEmail
+		}
+		return &emails[i], nil
 	}
 
-	var jsonResponse struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
+	return nil, ErrNoVerifiedEmail
+}
+
+// apiGet fetches the GitHub API resource into v
+func (provider *Github) apiGet(accessToken string, resourceURL *url.URL, v interface{}) error {
+	req, err := http.NewRequest("GET", resourceURL.String(), nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("Authorization", "token "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	err = json.Unmarshal(body, &jsonResponse)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	authResponse := AuthResponse{}
-	authResponse.Email = jsonResponse.Email
-	authResponse.EmailVerified = true
-	authResponse.Name = jsonResponse.Name
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
 
-	return &authResponse, nil
+	err = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d from %q %s", resp.StatusCode, resourceURL.String(), body)
+	}
+
+	return json.Unmarshal(body, v)
 }
 
 //Data provides provider specific data
@@ -146,20 +202,54 @@ func (provider *Github) Data() *ProviderData {
 	return provider.pData
 }
 
-//NewGitHubProvider gives new Github provider
-func NewGitHubProvider() Provider {
+//NewGitHubProvider gives new Github provider for github.com configured with the github_* options
+func NewGitHubProvider(c *config.Configuration) Provider {
+	return NewGitHubProviderFromConfig(config.ProviderConfig{
+		Name:         "github",
+		Type:         "github",
+		ClientID:     c.GithubClientID,
+		ClientSecret: c.GithubClientSecret,
+		AuthScope:    c.GithubAuthScope,
+		AllowSignUp:  c.GithubAllowSignUp,
+		Orgs:         c.GithubOrgs,
+		Teams:        c.GithubTeams,
+	})
+}
+
+//NewGitHubProviderFromConfig gives new Github provider for the named provider.
+//base_url points it to a Github Enterprise Server, whose API is served under /api/v3 unless api_url is given
+func NewGitHubProviderFromConfig(providerConfig config.ProviderConfig) Provider {
+	baseURL := &url.URL{Scheme: "https", Host: "github.com"}
+	apiURL := &url.URL{Scheme: "https", Host: "api.github.com"}
+	if providerConfig.BaseURL != "" {
+		if parsed, err := url.Parse(strings.TrimSuffix(providerConfig.BaseURL, "/")); err == nil {
+			baseURL = parsed
+			apiURL = &url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: parsed.Path + "/api/v3"}
+		} else {
+			log.Printf("invalid base_url of provider %s: %v\n", providerConfig.Name, err)
+		}
+	}
+
+	if providerConfig.APIURL != "" {
+		if parsed, err := url.Parse(strings.TrimSuffix(providerConfig.APIURL, "/")); err == nil {
+			apiURL = parsed
+		} else {
+			log.Printf("invalid api_url of provider %s: %v\n", providerConfig.Name, err)
+		}
+	}
+
 	pData := ProviderData{}
-	pData.ProviderName = "github"
-	pData.LoginURL = &url.URL{Scheme: "https",
-		Host: "github.com",
-		Path: "/login/oauth/authorize",
-	}
-	pData.RedeemURL = &url.URL{Scheme: "https",
-		Host: "github.com",
-		Path: "/login/oauth/access_token"}
-	pData.ValidateURL = &url.URL{Scheme: "https",
-		Host: "api.github.com",
-		Path: "/user"}
-
-	return &Github{pData: &pData}
+	pData.ProviderName = providerConfig.Name
+	pData.LoginURL = &url.URL{Scheme: baseURL.Scheme,
+		Host: baseURL.Host,
+		Path: baseURL.Path + "/login/oauth/authorize",
+	}
+	pData.RedeemURL = &url.URL{Scheme: baseURL.Scheme,
+		Host: baseURL.Host,
+		Path: baseURL.Path + "/login/oauth/access_token"}
+	pData.ValidateURL = &url.URL{Scheme: apiURL.Scheme,
+		Host: apiURL.Host,
+		Path: apiURL.Path + "/user"}
+
+	return &Github{pData: &pData, apiURL: apiURL, config: providerConfig}
 }
@@ -0,0 +1,174 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// githubMembershipTTL is how long the membership of an access token is trusted before it is checked again
+	githubMembershipTTL = 5 * time.Minute
+
+	// githubPageSize is the largest page the GitHub API returns
+	githubPageSize = 100
+
+	// githubMaxPages bounds the pages fetched for a single listing
+	githubMaxPages = 10
+)
+
+//ErrNotMember is returned when the GitHub user is not a member of any of the allowed organizations or teams
+var ErrNotMember = errors.New("not a member of the allowed GitHub organizations or teams")
+
+// githubMembership is the outcome of the membership check of an access token
+type githubMembership struct {
+	groups    []string
+	allowed   bool
+	expiresAt time.Time
+}
+
+// githubMemberships caches the membership checks by the hash of the access token
+var githubMemberships = struct {
+	sync.Mutex
+	entries map[string]githubMembership
+}{entries: make(map[string]githubMembership)}
+
+// checkMembership returns the teams of the user, as "org/team-slug", after checking the user is a member
+// of the allowed orgs or teams if any are configured. The outcome is re-checked every githubMembershipTTL
+func (provider *Github) checkMembership(accessToken string) ([]string, error) {
+	hashed := sha256.Sum256([]byte(provider.pData.ProviderName + ":" + accessToken))
+	key := hex.EncodeToString(hashed[:])
+
+	githubMemberships.Lock()
+	membership, ok := githubMemberships.entries[key]
+	githubMemberships.Unlock()
+	if !ok || time.Now().After(membership.expiresAt) {
+		var err error
+		membership, err = provider.fetchMembership(accessToken)
+		if err != nil {
+			return nil, err
+		}
+
+		githubMemberships.Lock()
+		for k, entry := range githubMemberships.entries {
+			if time.Now().After(entry.expiresAt) {
+				delete(githubMemberships.entries, k)
+			}
+		}
+		githubMemberships.entries[key] = membership
+		githubMemberships.Unlock()
+	}
+
+	if !membership.allowed {
+		return nil, ErrNotMember
+	}
+
+	return membership.groups, nil
+}
+
+func (provider *Github) fetchMembership(accessToken string) (githubMembership, error) {
+	allowedOrgs, allowedTeams := provider.config.Orgs, provider.config.Teams
+	restricted := len(allowedOrgs) > 0 || len(allowedTeams) > 0
+	membership := githubMembership{allowed: !restricted, expiresAt: time.Now().Add(githubMembershipTTL)}
+
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	err := provider.apiGetAll(accessToken, "/user/teams", &teams)
+	if err != nil {
+		if restricted {
+			return membership, err
+		}
+
+		// the groups are optional without restrictions, the token may lack the read:org scope
+		log.Println(err)
+		return membership, nil
+	}
+
+	orgs := make(map[string]bool)
+	for _, team := range teams {
+		group := fmt.Sprintf("%s/%s", team.Organization.Login, team.Slug)
+		membership.groups = append(membership.groups, group)
+		orgs[strings.ToLower(team.Organization.Login)] = true
+		if containsFold(allowedTeams, group) {
+			membership.allowed = true
+		}
+	}
+
+	if membership.allowed || len(allowedOrgs) == 0 {
+		return membership, nil
+	}
+
+	// members of an organization aren't necessarily in any of its teams
+	var userOrgs []struct {
+		Login string `json:"login"`
+	}
+	err = provider.apiGetAll(accessToken, "/user/orgs", &userOrgs)
+	if err != nil {
+		return membership, err
+	}
+
+	for _, org := range userOrgs {
+		orgs[strings.ToLower(org.Login)] = true
+	}
+
+	for _, org := range allowedOrgs {
+		if orgs[strings.ToLower(org)] {
+			membership.allowed = true
+		}
+	}
+
+	return membership, nil
+}
+
+// apiGetAll fetches every page of the GitHub API listing into v, a pointer to a slice
+func (provider *Github) apiGetAll(accessToken string, path string, v interface{}) error {
+	var all []json.RawMessage
+	for page := 1; page <= githubMaxPages; page++ {
+		resourceURL := *provider.apiURL
+		resourceURL.Path = strings.TrimSuffix(resourceURL.Path, "/") + path
+		resourceURL.RawQuery = url.Values{
+			"per_page": {strconv.Itoa(githubPageSize)},
+			"page":     {strconv.Itoa(page)},
+		}.Encode()
+
+		var items []json.RawMessage
+		err := provider.apiGet(accessToken, &resourceURL, &items)
+		if err != nil {
+			return err
+		}
+
+		all = append(all, items...)
+		if len(items) < githubPageSize {
+			break
+		}
+	}
+
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// containsFold reports whether the values contain the value, ignoring the case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
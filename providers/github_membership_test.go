@@ -0,0 +1,133 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+// newFakeGithubAPI serves the user, teams and organizations of the "valid_token" user
+func newFakeGithubAPI(calls *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	authorized := func(handler func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(calls, 1)
+			if r.Header.Get("Authorization") != "token valid_token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	mux.HandleFunc("/user", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"login":      "octocat",
+			"name":       "The Octocat",
+			"email":      "octocat@github.com",
+			"avatar_url": "https://avatars.githubusercontent.com/u/583231",
+		})
+	}))
+	mux.HandleFunc("/user/emails", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"email": "octocat@users.noreply.github.com", "primary": false, "verified": true},
+			{"email": "private@example.com", "primary": true, "verified": true},
+		})
+	}))
+	mux.HandleFunc("/user/teams", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"slug": "platform", "organization": map[string]string{"login": "acme"}},
+			{"slug": "design", "organization": map[string]string{"login": "other"}},
+		})
+	}))
+	mux.HandleFunc("/user/orgs", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]string{{"login": "acme"}, {"login": "other"}, {"login": "no-teams"}})
+	}))
+
+	return httptest.NewServer(mux)
+}
+
+func newTestGithubProvider(apiURL string) *Github {
+	provider := NewGitHubProvider(&config.Config).(*Github)
+	provider.apiURL, _ = url.Parse(apiURL)
+	provider.pData.ValidateURL, _ = url.Parse(apiURL + "/user")
+	return provider
+}
+
+func TestGithub_Membership(t *testing.T) {
+	var calls int32
+	api := newFakeGithubAPI(&calls)
+	defer api.Close()
+
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+
+	tests := []struct {
+		orgs        []string
+		teams       []string
+		expectError bool
+	}{
+		{},
+		{orgs: []string{"ACME"}},
+		{orgs: []string{"no-teams"}},
+		{orgs: []string{"unknown"}, expectError: true},
+		{teams: []string{"acme/platform"}},
+		{teams: []string{"acme/design"}, expectError: true},
+		{orgs: []string{"unknown"}, teams: []string{"other/design"}},
+	}
+
+	for _, test := range tests {
+		config.Config.GithubOrgs, config.Config.GithubTeams = test.orgs, test.teams
+		githubMemberships.entries = make(map[string]githubMembership)
+
+		response, err := newTestGithubProvider(api.URL).GetProfileDataFromAccessToken("valid_token")
+		if test.expectError {
+			assert.Equal(t, ErrNotMember, err)
+			continue
+		}
+
+		assert.Nil(t, err)
+		assert.Equal(t, "octocat", response.Login)
+		assert.Equal(t, "private@example.com", response.Email)
+		assert.True(t, response.EmailVerified)
+		assert.Equal(t, []string{"acme/platform", "other/design"}, response.Groups)
+	}
+
+	_, err := newTestGithubProvider(api.URL).GetProfileDataFromAccessToken("invalid_token")
+	assert.NotNil(t, err)
+}
+
+func TestGithub_MembershipCache(t *testing.T) {
+	var calls int32
+	api := newFakeGithubAPI(&calls)
+	defer api.Close()
+
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+	config.Config.GithubOrgs = []string{"no-teams"}
+	githubMemberships.entries = make(map[string]githubMembership)
+
+	provider := newTestGithubProvider(api.URL)
+	_, err := provider.checkMembership("valid_token")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the membership is trusted until it expires
+	_, err = provider.checkMembership("valid_token")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	for key, entry := range githubMemberships.entries {
+		entry.expiresAt = entry.expiresAt.Add(-2 * githubMembershipTTL)
+		githubMemberships.entries[key] = entry
+	}
+	_, err = provider.checkMembership("valid_token")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
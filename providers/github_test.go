@@ -1,11 +1,14 @@
 package providers
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
 	"github.com/bmizerany/assert"
+	"github.com/vedhavyas/oauth2_central/config"
 )
 
 func TestGithub_RefreshAccessToken(t *testing.T) {
@@ -17,7 +20,7 @@ func TestGithub_RefreshAccessToken(t *testing.T) {
 		{refreshToken: "sdbdfsdfsdgsdgvsbvhsfbhv", expectedResponse: nil},
 	}
 
-	provider := NewGitHubProvider()
+	provider := NewGitHubProvider(&config.Config)
 
 	for _, test := range tests {
 		result, _ := provider.RefreshAccessToken(test.refreshToken)
@@ -37,10 +40,10 @@ func TestGithub_RedeemCode(t *testing.T) {
 		{code: "jhkdsdvsdvafvsadf", redirectURL: redirectURL, expectedResult: nil},
 	}
 
-	provider := NewGitHubProvider()
+	provider := NewGitHubProvider(&config.Config)
 
 	for _, test := range tests {
-		response, _ := provider.RedeemCode(test.code, test.redirectURL, test.state)
+		response, _ := provider.RedeemCode(test.code, test.redirectURL, &AuthState{State: test.state})
 		assert.Equal(t, response, test.expectedResult)
 	}
 }
@@ -54,9 +57,112 @@ func TestGithub_GetProfileDataFromAccessToken(t *testing.T) {
 		{accessToken: "sdsdggsabvsbvhsfbhv", expectedResponse: nil},
 	}
 
-	provider := NewGitHubProvider()
+	provider := NewGitHubProvider(&config.Config)
 	for _, test := range tests {
 		response, _ := provider.GetProfileDataFromAccessToken(test.accessToken)
 		assert.Equal(t, response, test.expectedResponse)
 	}
 }
+
+func TestGithub_getPrimaryEmail(t *testing.T) {
+	tests := []struct {
+		emails        []githubEmail
+		expectedEmail *githubEmail
+		expectedError error
+	}{
+		{
+			emails:        []githubEmail{{Email: "other@example.com", Verified: true}, {Email: "primary@example.com", Primary: true, Verified: true}},
+			expectedEmail: &githubEmail{Email: "primary@example.com", Primary: true, Verified: true},
+		},
+		{
+			emails:        []githubEmail{{Email: "primary@example.com", Primary: true}, {Email: "other@example.com", Verified: true}},
+			expectedError: ErrNoVerifiedEmail,
+		},
+		{
+			emails:        []githubEmail{{Email: "other@example.com", Verified: true}},
+			expectedError: ErrNoVerifiedEmail,
+		},
+		{
+			emails:        []githubEmail{{Email: "primary@example.com", Primary: true}},
+			expectedError: ErrNoVerifiedEmail,
+		},
+		{
+			expectedError: ErrNoVerifiedEmail,
+		},
+	}
+
+	for _, test := range tests {
+		emails := test.emails
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if emails == nil {
+				emails = []githubEmail{}
+			}
+			json.NewEncoder(w).Encode(emails)
+		}))
+
+		email, err := newTestGithubProvider(api.URL).getPrimaryEmail("valid_token")
+		assert.Equal(t, test.expectedEmail, email)
+		assert.Equal(t, test.expectedError, err)
+		api.Close()
+	}
+}
+
+func TestNewGitHubProviderFromConfig(t *testing.T) {
+	tests := []struct {
+		providerConfig   config.ProviderConfig
+		expectedLoginURL string
+		expectedRedeem   string
+		expectedValidate string
+	}{
+		{
+			providerConfig:   config.ProviderConfig{Name: "github"},
+			expectedLoginURL: "https://github.com/login/oauth/authorize",
+			expectedRedeem:   "https://github.com/login/oauth/access_token",
+			expectedValidate: "https://api.github.com/user",
+		},
+		{
+			providerConfig:   config.ProviderConfig{Name: "ghe", BaseURL: "https://github.example.com/"},
+			expectedLoginURL: "https://github.example.com/login/oauth/authorize",
+			expectedRedeem:   "https://github.example.com/login/oauth/access_token",
+			expectedValidate: "https://github.example.com/api/v3/user",
+		},
+		{
+			providerConfig:   config.ProviderConfig{Name: "ghe", BaseURL: "https://example.com/github", APIURL: "https://api.example.com"},
+			expectedLoginURL: "https://example.com/github/login/oauth/authorize",
+			expectedRedeem:   "https://example.com/github/login/oauth/access_token",
+			expectedValidate: "https://api.example.com/user",
+		},
+	}
+
+	for _, test := range tests {
+		provider := NewGitHubProviderFromConfig(test.providerConfig)
+		assert.Equal(t, test.providerConfig.Name, provider.Data().ProviderName)
+		assert.Equal(t, test.expectedLoginURL, provider.Data().LoginURL.String())
+		assert.Equal(t, test.expectedRedeem, provider.Data().RedeemURL.String())
+		assert.Equal(t, test.expectedValidate, provider.Data().ValidateURL.String())
+	}
+}
+
+func TestGetProvider_GithubEnterprise(t *testing.T) {
+	var calls int32
+	api := newFakeGithubAPI(&calls)
+	defer api.Close()
+
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+	config.Config.Providers = []config.ProviderConfig{
+		{Name: "ghe", Type: "github", ClientID: "ghe_client", BaseURL: "https://github.example.com", APIURL: api.URL, Teams: []string{"acme/platform"}},
+	}
+
+	// github.com and the enterprise server are independent providers
+	provider, ok := GetProvider(&config.Config, "ghe").(*Github)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "ghe", provider.Data().ProviderName)
+	assert.Equal(t, "ghe_client", provider.config.ClientID)
+	assert.Equal(t, "github", GetProvider(&config.Config, "github").Data().ProviderName)
+
+	response, err := provider.GetProfileDataFromAccessToken("valid_token")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "octocat", response.Login)
+	assert.Equal(t, []string{"acme/platform", "other/design"}, response.Groups)
+}
@@ -0,0 +1,184 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+//GitLab for gitlab.com and self-managed GitLab Authentication
+type GitLab struct {
+	pData  *ProviderData
+	apiURL *url.URL
+	config config.ProviderConfig
+}
+
+//RedirectToAuthPage redirects to GitLab Auth page
+func (provider *GitLab) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	authURL := *provider.pData.LoginURL
+	params, _ := url.ParseQuery(authURL.RawQuery)
+	params.Set("response_type", "code")
+	params.Set("scope", provider.config.AuthScope)
+	params.Set("client_id", provider.config.ClientID)
+	params.Set("redirect_uri", GetAuthCallBackURL(r))
+	authState.setAuthParams(params, false)
+	authURL.RawQuery = params.Encode()
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+//RefreshAccessToken fetch new access token using the offline refresh token.
+//GitLab rotates the refresh tokens, the one returned replaces the one used
+func (provider *GitLab) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("refresh_token", refreshToken)
+	params.Set("grant_type", "refresh_token")
+
+	redeemResponse, err := provider.requestToken(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if redeemResponse.RefreshToken == "" {
+		redeemResponse.RefreshToken = refreshToken
+	}
+	return redeemResponse, nil
+}
+
+//RedeemCode gets access token and refresh token using the code provided
+func (provider *GitLab) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("redirect_uri", redirectURL)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+	authState.setRedeemParams(params)
+	return provider.requestToken(params)
+}
+
+// requestToken requests the tokens from the token endpoint. The ID token GitLab returns for the openid scope
+// is dropped as the profile always comes from the API, where the membership is checked
+func (provider *GitLab) requestToken(params url.Values) (*RedeemResponse, error) {
+	redeemResponse, err := requestToken(provider.pData.RedeemURL, provider.config.ClientID, provider.config.ClientSecret, authInParams, params)
+	if err != nil {
+		return nil, err
+	}
+
+	redeemResponse.IDToken = ""
+	return redeemResponse, nil
+}
+
+//GetProfileDataFromAccessToken gets user profile from access token.
+//The groups of the user are returned as their full paths once the membership is checked.
+//The profile is cached along with the membership so that every request doesn't hit the GitLab API
+func (provider *GitLab) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	return provider.checkMembership(accessToken)
+}
+
+// fetchProfile fetches the profile of the user and returns it along with the user ID
+func (provider *GitLab) fetchProfile(accessToken string) (*AuthResponse, int64, error) {
+	var jsonResponse struct {
+		ID          int64   `json:"id"`
+		Username    string  `json:"username"`
+		Name        string  `json:"name"`
+		Email       string  `json:"email"`
+		AvatarURL   string  `json:"avatar_url"`
+		ConfirmedAt *string `json:"confirmed_at"`
+	}
+
+	err := provider.apiGet(accessToken, provider.pData.ValidateURL, &jsonResponse)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	authResponse := AuthResponse{}
+	authResponse.Email = jsonResponse.Email
+	authResponse.EmailVerified = jsonResponse.ConfirmedAt != nil
+	authResponse.Name = jsonResponse.Name
+	authResponse.Login = jsonResponse.Username
+	authResponse.Picture = jsonResponse.AvatarURL
+
+	return &authResponse, jsonResponse.ID, nil
+}
+
+// errGitLabNotFound is returned by apiGet for the resources missing or not visible to the user
+var errGitLabNotFound = errors.New("gitlab resource not found")
+
+// apiGet fetches the GitLab API resource into v
+func (provider *GitLab) apiGet(accessToken string, resourceURL *url.URL, v interface{}) error {
+	req, err := http.NewRequest("GET", resourceURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errGitLabNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d from %q %s", resp.StatusCode, resourceURL.String(), body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+//Data provides provider specific data
+func (provider *GitLab) Data() *ProviderData {
+	return provider.pData
+}
+
+//NewGitLabProvider gives new GitLab provider for the named provider.
+//base_url points it to a self-managed instance, whose API is served under /api/v4
+func NewGitLabProvider(providerConfig config.ProviderConfig) Provider {
+	if providerConfig.AuthScope == "" {
+		providerConfig.AuthScope = "read_user read_api"
+	}
+
+	baseURL := &url.URL{Scheme: "https", Host: "gitlab.com"}
+	if providerConfig.BaseURL != "" {
+		if parsed, err := url.Parse(strings.TrimSuffix(providerConfig.BaseURL, "/")); err == nil {
+			baseURL = parsed
+		} else {
+			log.Printf("invalid base_url of provider %s: %v\n", providerConfig.Name, err)
+		}
+	}
+
+	apiURL := &url.URL{Scheme: baseURL.Scheme, Host: baseURL.Host, Path: baseURL.Path + "/api/v4"}
+
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	pData.ClientID = providerConfig.ClientID
+	pData.LoginURL = &url.URL{Scheme: baseURL.Scheme,
+		Host: baseURL.Host,
+		Path: baseURL.Path + "/oauth/authorize"}
+	pData.RedeemURL = &url.URL{Scheme: baseURL.Scheme,
+		Host: baseURL.Host,
+		Path: baseURL.Path + "/oauth/token"}
+	pData.ValidateURL = &url.URL{Scheme: apiURL.Scheme,
+		Host: apiURL.Host,
+		Path: apiURL.Path + "/user"}
+
+	return &GitLab{pData: &pData, apiURL: apiURL, config: providerConfig}
+}
@@ -0,0 +1,195 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gitlabMembershipTTL is how long the profile and the membership of an access token are trusted before they are fetched again
+	gitlabMembershipTTL = 5 * time.Minute
+
+	// gitlabPageSize is the largest page the GitLab API returns
+	gitlabPageSize = 100
+
+	// gitlabMaxPages bounds the pages fetched for a single listing
+	gitlabMaxPages = 10
+
+	// gitlabGuestAccess is the lowest access level of a group member
+	gitlabGuestAccess = 10
+)
+
+//ErrNotGitLabMember is returned when the GitLab user is not a member of any of the allowed groups or projects
+var ErrNotGitLabMember = errors.New("not a member of the allowed GitLab groups or projects")
+
+// gitlabMembership is the profile of the user of an access token along with the outcome of its membership check
+type gitlabMembership struct {
+	profile   AuthResponse
+	allowed   bool
+	expiresAt time.Time
+}
+
+// gitlabMemberships caches the profiles and the membership checks by the hash of the access token
+var gitlabMemberships = struct {
+	sync.Mutex
+	entries map[string]gitlabMembership
+}{entries: make(map[string]gitlabMembership)}
+
+// checkMembership returns the profile of the user, with the full paths of the user's groups, after checking the user is
+// a member of the allowed groups or projects if any are configured. Both are fetched again every gitlabMembershipTTL
+func (provider *GitLab) checkMembership(accessToken string) (*AuthResponse, error) {
+	hashed := sha256.Sum256([]byte(provider.pData.ProviderName + ":" + accessToken))
+	key := hex.EncodeToString(hashed[:])
+
+	gitlabMemberships.Lock()
+	membership, ok := gitlabMemberships.entries[key]
+	gitlabMemberships.Unlock()
+	if !ok || time.Now().After(membership.expiresAt) {
+		var err error
+		membership, err = provider.fetchMembership(accessToken)
+		if err != nil {
+			return nil, err
+		}
+
+		gitlabMemberships.Lock()
+		for k, entry := range gitlabMemberships.entries {
+			if time.Now().After(entry.expiresAt) {
+				delete(gitlabMemberships.entries, k)
+			}
+		}
+		gitlabMemberships.entries[key] = membership
+		gitlabMemberships.Unlock()
+	}
+
+	if !membership.allowed {
+		return nil, ErrNotGitLabMember
+	}
+
+	authResponse := membership.profile
+	return &authResponse, nil
+}
+
+func (provider *GitLab) fetchMembership(accessToken string) (gitlabMembership, error) {
+	profile, userID, err := provider.fetchProfile(accessToken)
+	if err != nil {
+		return gitlabMembership{}, err
+	}
+
+	allowedGroups, allowedProjects := provider.config.Groups, provider.config.Projects
+	restricted := len(allowedGroups) > 0 || len(allowedProjects) > 0
+	membership := gitlabMembership{profile: *profile, allowed: !restricted, expiresAt: time.Now().Add(gitlabMembershipTTL)}
+
+	var groups []struct {
+		FullPath string `json:"full_path"`
+	}
+	query := url.Values{"min_access_level": {strconv.Itoa(gitlabGuestAccess)}}
+	err = provider.apiGetAll(accessToken, "/groups", query, &groups)
+	if err != nil {
+		if restricted {
+			return membership, err
+		}
+
+		// the groups are optional without restrictions, the token may lack the read_api scope
+		log.Println(err)
+		return membership, nil
+	}
+
+	for _, group := range groups {
+		membership.profile.Groups = append(membership.profile.Groups, group.FullPath)
+		for _, allowed := range allowedGroups {
+			if isGitLabSubgroup(group.FullPath, allowed) {
+				membership.allowed = true
+			}
+		}
+	}
+
+	if membership.allowed {
+		return membership, nil
+	}
+
+	// project members aren't necessarily members of the project's group
+	for _, project := range allowedProjects {
+		member, err := provider.isProjectMember(accessToken, project, userID)
+		if err != nil {
+			return membership, err
+		}
+
+		if member {
+			membership.allowed = true
+			break
+		}
+	}
+
+	return membership, nil
+}
+
+// isProjectMember checks whether the user is a member of the project, directly or through its groups
+func (provider *GitLab) isProjectMember(accessToken string, project string, userID int64) (bool, error) {
+	// the project path is a single, escaped, path segment
+	memberURL, err := url.Parse(strings.TrimSuffix(provider.apiURL.String(), "/") +
+		"/projects/" + url.PathEscape(project) + "/members/all/" + strconv.FormatInt(userID, 10))
+	if err != nil {
+		return false, err
+	}
+
+	var member struct {
+		ID int64 `json:"id"`
+	}
+	err = provider.apiGet(accessToken, memberURL, &member)
+	if err == errGitLabNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return member.ID == userID, nil
+}
+
+// apiGetAll fetches every page of the GitLab API listing into v, a pointer to a slice
+func (provider *GitLab) apiGetAll(accessToken string, path string, query url.Values, v interface{}) error {
+	var all []json.RawMessage
+	for page := 1; page <= gitlabMaxPages; page++ {
+		resourceURL := *provider.apiURL
+		resourceURL.Path = strings.TrimSuffix(resourceURL.Path, "/") + path
+		params := url.Values{}
+		for k, values := range query {
+			params[k] = values
+		}
+		params.Set("per_page", strconv.Itoa(gitlabPageSize))
+		params.Set("page", strconv.Itoa(page))
+		resourceURL.RawQuery = params.Encode()
+
+		var items []json.RawMessage
+		err := provider.apiGet(accessToken, &resourceURL, &items)
+		if err != nil {
+			return err
+		}
+
+		all = append(all, items...)
+		if len(items) < gitlabPageSize {
+			break
+		}
+	}
+
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// isGitLabSubgroup reports whether the group is the allowed group or one of its subgroups
+func isGitLabSubgroup(group, allowed string) bool {
+	group, allowed = strings.ToLower(group), strings.ToLower(strings.Trim(allowed, "/"))
+	return group == allowed || strings.HasPrefix(group, allowed+"/")
+}
@@ -0,0 +1,218 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+// newFakeGitLab serves the token endpoint and the API of a GitLab instance for the "valid_token" user,
+// counting the profile fetches in userFetches
+func newFakeGitLab(userFetches *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	authorized := func(handler func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer valid_token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	mux.HandleFunc("/gitlab/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_id") != "client" || r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			if r.Form.Get("code") != "valid_code" || r.Form.Get("code_verifier") != "valid_verifier" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh_token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "valid_token",
+			"refresh_token": "rotated_token",
+			"expires_in":    7200,
+			"id_token":      "an.unverifiable.id_token",
+		})
+	})
+	mux.HandleFunc("/gitlab/api/v4/user", authorized(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(userFetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":           42,
+			"username":     "john",
+			"name":         "John",
+			"email":        "john@example.com",
+			"confirmed_at": "2020-01-01T00:00:00Z",
+		})
+	}))
+	mux.HandleFunc("/gitlab/api/v4/groups", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("min_access_level") != "10" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"full_path": "infra/build"}, {"full_path": "docs"}})
+	}))
+	mux.HandleFunc("/gitlab/api/v4/projects/", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/gitlab/api/v4/projects/tools%2Fdeploy/members/all/42" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 42, "username": "john"})
+	}))
+
+	return httptest.NewServer(mux)
+}
+
+func TestGitLab_RedeemAndRefresh(t *testing.T) {
+	var userFetches int32
+	server := newFakeGitLab(&userFetches)
+	defer server.Close()
+
+	provider := NewGitLabProvider(config.ProviderConfig{
+		Name:         "gitlab",
+		BaseURL:      server.URL + "/gitlab/",
+		ClientID:     "client",
+		ClientSecret: "secret",
+	})
+
+	r := httptest.NewRequest("GET", "http://sso.example.com/oauth2/start", nil)
+	w := httptest.NewRecorder()
+	provider.RedirectToAuthPage(w, r, &AuthState{State: "state", CodeVerifier: "valid_verifier"})
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/gitlab/oauth/authorize", location.Path)
+	assert.Equal(t, "read_user read_api", location.Query().Get("scope"))
+	assert.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+
+	redeemResponse, err := provider.RedeemCode("valid_code", "http://sso.example.com/oauth2/callback", &AuthState{CodeVerifier: "valid_verifier"})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid_token", redeemResponse.AccessToken)
+	assert.Equal(t, "rotated_token", redeemResponse.RefreshToken)
+	assert.False(t, redeemResponse.ExpiresOn.IsZero())
+
+	// the ID token returned for the openid scope is dropped, the profile comes from the API with the membership
+	assert.Empty(t, redeemResponse.IDToken)
+
+	_, err = provider.RedeemCode("invalid_code", "http://sso.example.com/oauth2/callback", &AuthState{CodeVerifier: "valid_verifier"})
+	assert.NotNil(t, err)
+
+	redeemResponse, err = provider.RefreshAccessToken("refresh_token")
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated_token", redeemResponse.RefreshToken)
+	assert.Empty(t, redeemResponse.IDToken)
+
+	_, err = provider.RefreshAccessToken("invalid_token")
+	assert.NotNil(t, err)
+}
+
+func TestGitLab_Membership(t *testing.T) {
+	var userFetches int32
+	server := newFakeGitLab(&userFetches)
+	defer server.Close()
+
+	tests := []struct {
+		groups      []string
+		projects    []string
+		expectError bool
+	}{
+		{},
+		{groups: []string{"infra/build"}},
+		{groups: []string{"INFRA"}},
+		{groups: []string{"infra/deploy"}, expectError: true},
+		{groups: []string{"inf"}, expectError: true},
+		{projects: []string{"tools/deploy"}},
+		{projects: []string{"tools/other"}, expectError: true},
+		{groups: []string{"other"}, projects: []string{"tools/deploy"}},
+	}
+
+	for _, test := range tests {
+		gitlabMemberships.Lock()
+		gitlabMemberships.entries = make(map[string]gitlabMembership)
+		gitlabMemberships.Unlock()
+
+		provider := NewGitLabProvider(config.ProviderConfig{
+			Name:     "gitlab",
+			BaseURL:  server.URL + "/gitlab",
+			Groups:   test.groups,
+			Projects: test.projects,
+		})
+
+		authResponse, err := provider.GetProfileDataFromAccessToken("valid_token")
+		if test.expectError {
+			assert.Equal(t, ErrNotGitLabMember, err)
+			continue
+		}
+
+		if assert.Nil(t, err) {
+			assert.Equal(t, "john", authResponse.Login)
+			assert.Equal(t, "john@example.com", authResponse.Email)
+			assert.True(t, authResponse.EmailVerified)
+			assert.Equal(t, []string{"infra/build", "docs"}, authResponse.Groups)
+		}
+	}
+
+	_, err := NewGitLabProvider(config.ProviderConfig{Name: "gitlab", BaseURL: server.URL + "/gitlab"}).
+		GetProfileDataFromAccessToken("invalid_token")
+	assert.NotNil(t, err)
+}
+
+func TestGitLab_ProfileCache(t *testing.T) {
+	var userFetches int32
+	server := newFakeGitLab(&userFetches)
+	defer server.Close()
+
+	gitlabMemberships.Lock()
+	gitlabMemberships.entries = make(map[string]gitlabMembership)
+	gitlabMemberships.Unlock()
+
+	tests := []struct {
+		groups      []string
+		expectError bool
+	}{
+		{groups: []string{"infra"}},
+		{groups: []string{"other"}, expectError: true},
+	}
+
+	for _, test := range tests {
+		provider := NewGitLabProvider(config.ProviderConfig{Name: "gitlab-" + test.groups[0], BaseURL: server.URL + "/gitlab", Groups: test.groups})
+		atomic.StoreInt32(&userFetches, 0)
+
+		// the profile is fetched along with the membership and cached with it, allowed or not
+		for i := 0; i < 3; i++ {
+			authResponse, err := provider.GetProfileDataFromAccessToken("valid_token")
+			if test.expectError {
+				assert.Equal(t, ErrNotGitLabMember, err)
+				continue
+			}
+
+			if assert.Nil(t, err) {
+				assert.Equal(t, "john", authResponse.Login)
+				assert.Equal(t, []string{"infra/build", "docs"}, authResponse.Groups)
+			}
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&userFetches), test.groups[0])
+	}
+}
@@ -2,36 +2,39 @@ package providers
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 
 	"github.com/vedhavyas/oauth2_central/config"
 )
 
+// googleIssuer is the issuer of the ID tokens signed by Google
+const googleIssuer = "https://accounts.google.com"
+
 //GoogleProvider for Google Authorization
 type GoogleProvider struct {
-	pData *ProviderData
+	pData        *ProviderData
+	authScope    string
+	clientSecret string
 }
 
 //RedirectToAuthPage redirects to Google Auth page
-func (provider *GoogleProvider) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, state string) {
+func (provider *GoogleProvider) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
 	authURL := provider.pData.LoginURL
 	params, _ := url.ParseQuery(authURL.RawQuery)
 	params.Set("response_type", "code")
-	params.Set("scope", config.Config.GoogleAuthScope)
-	params.Set("client_id", config.Config.GoogleClientID)
+	params.Set("scope", provider.authScope)
+	params.Set("client_id", provider.pData.ClientID)
 	params.Set("redirect_uri", GetAuthCallBackURL(r))
 	params.Set("approval_prompt", "force")
-	params.Set("state", state)
-	if config.Config.GoogleDomain != "" {
-		params.Set("hd", config.Config.GoogleDomain)
+	authState.setAuthParams(params, true)
+	if provider.pData.HostedDomain != "" {
+		params.Set("hd", provider.pData.HostedDomain)
 	}
 	authURL.RawQuery = params.Encode()
 	http.Redirect(w, r, authURL.String(), http.StatusFound)
@@ -41,8 +44,8 @@ func (provider *GoogleProvider) RedirectToAuthPage(w http.ResponseWriter, r *htt
 func (provider *GoogleProvider) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
 	params := url.Values{}
 	params.Set("refresh_token", refreshToken)
-	params.Set("client_id", config.Config.GoogleClientID)
-	params.Set("client_secret", config.Config.GoogleClientSecret)
+	params.Set("client_id", provider.pData.ClientID)
+	params.Set("client_secret", provider.clientSecret)
 	params.Set("grant_type", "refresh_token")
 
 	req, err := http.NewRequest("POST", provider.pData.RedeemURL.String(), bytes.NewBufferString(params.Encode()))
@@ -51,7 +54,7 @@ func (provider *GoogleProvider) RefreshAccessToken(refreshToken string) (*Redeem
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -85,13 +88,14 @@ func (provider *GoogleProvider) RefreshAccessToken(refreshToken string) (*Redeem
 }
 
 //RedeemCode gets access token and refresh token using the code provided
-func (provider *GoogleProvider) RedeemCode(code string, redirectURL string, state string) (*RedeemResponse, error) {
+func (provider *GoogleProvider) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
 	params := url.Values{}
 	params.Add("redirect_uri", redirectURL)
-	params.Add("client_id", config.Config.GoogleClientID)
-	params.Add("client_secret", config.Config.GoogleClientSecret)
+	params.Add("client_id", provider.pData.ClientID)
+	params.Add("client_secret", provider.clientSecret)
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	authState.setRedeemParams(params)
 
 	var req *http.Request
 	req, err := http.NewRequest("POST", provider.pData.RedeemURL.String(), bytes.NewBufferString(params.Encode()))
@@ -100,7 +104,7 @@ func (provider *GoogleProvider) RedeemCode(code string, redirectURL string, stat
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +159,7 @@ func (provider *GoogleProvider) GetProfileDataFromAccessToken(accessToken string
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -191,13 +195,21 @@ func (provider *GoogleProvider) GetProfileDataFromAccessToken(accessToken string
 	return &authResponse, nil
 }
 
+//RevokeToken revokes the access or refresh token. Revoking the refresh token revokes
+//the access tokens issued with it as well
+func (provider *GoogleProvider) RevokeToken(token string) error {
+	params := url.Values{}
+	params.Set("token", token)
+	return revokeToken(provider.pData.RevokeURL, params)
+}
+
 //Data provides provider specific data
 func (provider *GoogleProvider) Data() *ProviderData {
 	return provider.pData
 }
 
-//NewGoogleProvider gives new Google provider
-func NewGoogleProvider() Provider {
+//NewGoogleProvider gives new Google provider configured with the google_* options
+func NewGoogleProvider(c *config.Configuration) Provider {
 	pData := ProviderData{}
 	pData.ProviderName = "google"
 	pData.LoginURL = &url.URL{Scheme: "https",
@@ -211,46 +223,15 @@ func NewGoogleProvider() Provider {
 	pData.ValidateURL = &url.URL{Scheme: "https",
 		Host: "www.googleapis.com",
 		Path: "/oauth2/v1/tokeninfo"}
+	pData.RevokeURL = &url.URL{Scheme: "https",
+		Host: "oauth2.googleapis.com",
+		Path: "/revoke"}
+	pData.JWKSURL = &url.URL{Scheme: "https",
+		Host: "www.googleapis.com",
+		Path: "/oauth2/v3/certs"}
+	pData.Issuer = googleIssuer
+	pData.ClientID = c.GoogleClientID
+	pData.HostedDomain = c.GoogleDomain
 
-	return &GoogleProvider{pData: &pData}
-}
-
-//GetProfileFromIDToken gets user profile from IDToken provided by Google
-func GetProfileFromIDToken(authResponse *AuthResponse, idToken string) error {
-	// id_token is a base64 encode ID token payload
-	// https://developers.google.com/accounts/docs/OAuth2Login#obtainuserinfo
-	jwt := strings.Split(idToken, ".")
-	b, err := jwtDecodeSegment(jwt[1])
-	if err != nil {
-		return err
-	}
-
-	var jsonResponse struct {
-		EmailVerified bool   `json:"email_verified"`
-		Hd            string `json:"hd"`
-		Email         string `json:"email"`
-		Name          string `json:"name"`
-	}
-
-	err = json.Unmarshal(b, &jsonResponse)
-	if err != nil {
-		return err
-	}
-
-	if config.Config.GoogleDomain != "" && config.Config.GoogleDomain != jsonResponse.Hd {
-		return errors.New("Email not from domain " + config.Config.GoogleDomain)
-	}
-
-	authResponse.Email = jsonResponse.Email
-	authResponse.EmailVerified = jsonResponse.EmailVerified
-	authResponse.Name = jsonResponse.Name
-	return nil
-}
-
-func jwtDecodeSegment(seg string) ([]byte, error) {
-	if l := len(seg) % 4; l > 0 {
-		seg += strings.Repeat("=", 4-l)
-	}
-
-	return base64.URLEncoding.DecodeString(seg)
+	return &GoogleProvider{pData: &pData, authScope: c.GoogleAuthScope, clientSecret: c.GoogleClientSecret}
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/bmizerany/assert"
+	"github.com/vedhavyas/oauth2_central/config"
 )
 
 func TestGoogleProvider_RefreshAccessToken(t *testing.T) {
@@ -17,7 +18,7 @@ func TestGoogleProvider_RefreshAccessToken(t *testing.T) {
 		{refreshToken: "sdbvssdfvfwevsdbvhsfbhv", expectedResponse: ""},
 	}
 
-	provider := NewGoogleProvider()
+	provider := NewGoogleProvider(&config.Config)
 
 	for _, test := range tests {
 		result, _ := provider.RefreshAccessToken(test.refreshToken)
@@ -38,10 +39,10 @@ func TestGoogleProvider_RedeemCode(t *testing.T) {
 		{code: "jhkdsdcrwcavsadf", redirectURL: redirectURL, expectedResult: nil},
 	}
 
-	provider := NewGoogleProvider()
+	provider := NewGoogleProvider(&config.Config)
 
 	for _, test := range tests {
-		response, _ := provider.RedeemCode(test.code, test.redirectURL, test.state)
+		response, _ := provider.RedeemCode(test.code, test.redirectURL, &AuthState{State: test.state})
 		assert.Equal(t, response, test.expectedResult)
 	}
 
@@ -56,7 +57,7 @@ func TestGoogleProvider_GetProfileDataFromAccessToken(t *testing.T) {
 		{accessToken: "sdbfsdfwfvsbvhsfbhv", expectedResponse: nil},
 	}
 
-	provider := NewGoogleProvider()
+	provider := NewGoogleProvider(&config.Config)
 	for _, test := range tests {
 		response, _ := provider.GetProfileDataFromAccessToken(test.accessToken)
 		assert.Equal(t, response, test.expectedResponse)
@@ -0,0 +1,246 @@
+package providers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// allowedClockSkew is the leeway given to exp and iat checks
+const allowedClockSkew = time.Minute
+
+//Error codes returned when an ID token fails validation
+const (
+	ErrCodeInvalidIDToken  = "invalid_id_token"
+	ErrCodeIDTokenExpired  = "id_token_expired"
+	ErrCodeInvalidIssuer   = "invalid_id_token_issuer"
+	ErrCodeInvalidAudience = "invalid_id_token_audience"
+	ErrCodeInvalidNonce    = "invalid_id_token_nonce"
+	ErrCodeInvalidTenant   = "invalid_id_token_tenant"
+)
+
+//IDTokenError is returned when an ID token fails validation
+type IDTokenError struct {
+	Code    string
+	message string
+}
+
+//Error returns error message
+func (err *IDTokenError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Code, err.message)
+}
+
+func newIDTokenError(code string, format string, args ...interface{}) error {
+	return &IDTokenError{Code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// audience is either a single string or an array of strings in the token
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*aud = audience(multiple)
+	return nil
+}
+
+func (aud audience) contains(clientID string) bool {
+	for _, value := range aud {
+		if value == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+//IDTokenClaims holds the claims of a verified ID token
+type IDTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	Name            string   `json:"name"`
+	Picture         string   `json:"picture"`
+	Hd              string   `json:"hd"`
+	Nonce           string   `json:"nonce"`
+	TenantID        string   `json:"tid"`
+	PreferredName   string   `json:"preferred_username"`
+	Groups          []string `json:"groups"`
+	Roles           []string `json:"roles"`
+}
+
+//IDTokenProfiler is implemented by the providers mapping their own claims of the verified ID tokens
+type IDTokenProfiler interface {
+	ProfileFromIDTokenClaims(claims *IDTokenClaims, authResponse *AuthResponse) error
+}
+
+//VerifyIDToken verifies the signature of the ID token against the provider's JWKS
+//and validates iss, aud, exp and iat claims, and the nonce if one was sent with the login
+func VerifyIDToken(pData *ProviderData, idToken string, nonce string) (*IDTokenClaims, error) {
+	if pData.JWKSURL == nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "provider %s has no JWKS URL", pData.ProviderName)
+	}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "malformed token")
+	}
+
+	rawHeader, err := jwtDecodeSegment(parts[0])
+	if err != nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "malformed header: %v", err)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "malformed header: %v", err)
+	}
+
+	signature, err := jwtDecodeSegment(parts[2])
+	if err != nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "malformed signature: %v", err)
+	}
+
+	key, err := getSigningKey(pData.JWKSURL.String(), header.KeyID)
+	if err != nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "%v", err)
+	}
+
+	err = verifySignature(header.Algorithm, key, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "%v", err)
+	}
+
+	payload, err := jwtDecodeSegment(parts[1])
+	if err != nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "malformed payload: %v", err)
+	}
+
+	claims := &IDTokenClaims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "malformed payload: %v", err)
+	}
+
+	// multi tenant issuers carry the tenant of the token, https://login.microsoftonline.com/{tenantid}/v2.0
+	expectedIssuer := strings.Replace(pData.Issuer, "{tenantid}", claims.TenantID, 1)
+	if !issuerMatches(expectedIssuer, claims.Issuer) {
+		return nil, newIDTokenError(ErrCodeInvalidIssuer, "unexpected issuer %q", claims.Issuer)
+	}
+
+	if pData.ClientID == "" || !claims.Audience.contains(pData.ClientID) {
+		return nil, newIDTokenError(ErrCodeInvalidAudience, "token not issued for %q", pData.ClientID)
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != pData.ClientID {
+		return nil, newIDTokenError(ErrCodeInvalidAudience, "unexpected authorized party %q", claims.AuthorizedParty)
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.Add(-allowedClockSkew).After(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, newIDTokenError(ErrCodeIDTokenExpired, "token expired")
+	}
+
+	if claims.IssuedAt == 0 || now.Add(allowedClockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, newIDTokenError(ErrCodeInvalidIDToken, "token issued in the future")
+	}
+
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, newIDTokenError(ErrCodeInvalidNonce, "nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+func verifySignature(algorithm string, key interface{}, signingInput string, signature []byte) error {
+	hashed := sha256.Sum256([]byte(signingInput))
+	switch algorithm {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 token signed with a non RSA key")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hashed[:], signature)
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("ES256 token signed with a non EC key")
+		}
+		if len(signature) != 64 {
+			return errors.New("invalid ES256 signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, hashed[:], r, s) {
+			return errors.New("invalid ES256 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// issuerMatches compares the issuers. Google issues tokens with and without the https scheme
+// https://developers.google.com/identity/protocols/OpenIDConnect#validatinganidtoken
+func issuerMatches(expected, received string) bool {
+	if expected == "" {
+		return false
+	}
+	if expected == received {
+		return true
+	}
+	return expected == googleIssuer && received == strings.TrimPrefix(googleIssuer, "https://")
+}
+
+//GetProfileFromIDToken verifies the IDToken provided by the provider and gets user profile from it
+func GetProfileFromIDToken(provider Provider, authResponse *AuthResponse, idToken string, nonce string) error {
+	claims, err := VerifyIDToken(provider.Data(), idToken, nonce)
+	if err != nil {
+		return err
+	}
+
+	hostedDomain := provider.Data().HostedDomain
+	if hostedDomain != "" && hostedDomain != claims.Hd {
+		return errors.New("Email not from domain " + hostedDomain)
+	}
+
+	authResponse.Email = claims.Email
+	authResponse.EmailVerified = claims.EmailVerified
+	authResponse.Name = claims.Name
+	authResponse.Picture = claims.Picture
+	if profiler, ok := provider.(IDTokenProfiler); ok {
+		return profiler.ProfileFromIDTokenClaims(claims, authResponse)
+	}
+	return nil
+}
+
+func jwtDecodeSegment(seg string) ([]byte, error) {
+	if l := len(seg) % 4; l > 0 {
+		seg += strings.Repeat("=", 4-l)
+	}
+
+	return base64.URLEncoding.DecodeString(seg)
+}
@@ -0,0 +1,266 @@
+package providers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSigner struct {
+	kid     string
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	enabled bool
+}
+
+func (signer *testSigner) jwk() map[string]string {
+	if signer.rsaKey != nil {
+		return map[string]string{
+			"kty": "RSA",
+			"kid": signer.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(signer.rsaKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signer.rsaKey.E)).Bytes()),
+		}
+	}
+
+	return map[string]string{
+		"kty": "EC",
+		"kid": signer.kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(signer.ecKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(signer.ecKey.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func (signer *testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	alg := "RS256"
+	if signer.ecKey != nil {
+		alg = "ES256"
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": signer.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	if signer.rsaKey != nil {
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, signer.rsaKey, crypto.SHA256, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		r, s, err := ecdsa.Sign(rand.Reader, signer.ecKey, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+type fakeJWKS struct {
+	sync.Mutex
+	signers []*testSigner
+	fetches int
+}
+
+func (jwks *fakeJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	jwks.Lock()
+	defer jwks.Unlock()
+	jwks.fetches++
+
+	keys := []map[string]string{}
+	for _, signer := range jwks.signers {
+		if signer.enabled {
+			keys = append(keys, signer.jwk())
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            "https://issuer.example.com",
+		"aud":            "client",
+		"sub":            "1234",
+		"email":          "john@example.com",
+		"email_verified": true,
+		"name":           "John",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaSigner := &testSigner{kid: "rsa", rsaKey: rsaKey, enabled: true}
+	ecSigner := &testSigner{kid: "ec", ecKey: ecKey, enabled: true}
+	forgedSigner := &testSigner{kid: "rsa", rsaKey: otherKey}
+
+	jwks := &fakeJWKS{signers: []*testSigner{rsaSigner, ecSigner}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	jwksURL, _ := url.Parse(server.URL)
+	pData := &ProviderData{ProviderName: "test", Issuer: "https://issuer.example.com", ClientID: "client", JWKSURL: jwksURL}
+
+	withClaim := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		signer       *testSigner
+		claims       map[string]interface{}
+		nonce        string
+		expectedCode string
+	}{
+		{signer: rsaSigner, claims: validClaims()},
+		{signer: ecSigner, claims: validClaims()},
+		{signer: rsaSigner, claims: withClaim("aud", []string{"other", "client"}), expectedCode: ErrCodeInvalidAudience},
+		{signer: rsaSigner, claims: withClaim("aud", "other"), expectedCode: ErrCodeInvalidAudience},
+		{signer: rsaSigner, claims: withClaim("iss", "https://evil.example.com"), expectedCode: ErrCodeInvalidIssuer},
+		{signer: rsaSigner, claims: withClaim("exp", time.Now().Add(-time.Hour).Unix()), expectedCode: ErrCodeIDTokenExpired},
+		{signer: rsaSigner, claims: withClaim("iat", time.Now().Add(time.Hour).Unix()), expectedCode: ErrCodeInvalidIDToken},
+		{signer: forgedSigner, claims: validClaims(), expectedCode: ErrCodeInvalidIDToken},
+		{signer: rsaSigner, claims: withClaim("nonce", "nonce"), nonce: "nonce"},
+		{signer: rsaSigner, claims: withClaim("nonce", "replayed"), nonce: "nonce", expectedCode: ErrCodeInvalidNonce},
+		{signer: rsaSigner, claims: validClaims(), nonce: "nonce", expectedCode: ErrCodeInvalidNonce},
+	}
+
+	for _, test := range tests {
+		claims, err := VerifyIDToken(pData, test.signer.sign(t, test.claims), test.nonce)
+		if test.expectedCode == "" {
+			assert.Nil(t, err)
+			assert.Equal(t, "john@example.com", claims.Email)
+			continue
+		}
+
+		assert.NotNil(t, err)
+		tokenErr, ok := err.(*IDTokenError)
+		assert.True(t, ok)
+		if ok {
+			assert.Equal(t, test.expectedCode, tokenErr.Code)
+		}
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, _ := json.Marshal(validClaims())
+	_, err = VerifyIDToken(pData, header+"."+base64.RawURLEncoding.EncodeToString(payload)+".", "")
+	assert.NotNil(t, err)
+}
+
+func TestVerifyIDToken_KeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldSigner := &testSigner{kid: "old", rsaKey: oldKey, enabled: true}
+	newSigner := &testSigner{kid: "new", rsaKey: newKey}
+	jwks := &fakeJWKS{signers: []*testSigner{oldSigner, newSigner}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	jwksURL, _ := url.Parse(server.URL)
+	pData := &ProviderData{ProviderName: "test", Issuer: "https://issuer.example.com", ClientID: "client", JWKSURL: jwksURL}
+
+	_, err = VerifyIDToken(pData, oldSigner.sign(t, validClaims()), "")
+	assert.Nil(t, err)
+
+	// the issuer rotates its keys, the cached set must be refreshed for the unknown kid
+	jwks.Lock()
+	newSigner.enabled = true
+	jwks.Unlock()
+	keySetCache.Lock()
+	keySetCache.sets[server.URL].fetchedAt = time.Now().Add(-jwksMinRefreshInterval)
+	keySetCache.Unlock()
+
+	_, err = VerifyIDToken(pData, newSigner.sign(t, validClaims()), "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, jwks.fetches)
+
+	// unknown kids within the refresh interval don't hit the issuer again
+	unknownSigner := &testSigner{kid: "unknown", rsaKey: newKey}
+	_, err = VerifyIDToken(pData, unknownSigner.sign(t, validClaims()), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, jwks.fetches)
+}
+
+func TestGetSigningKey_SlowJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer slow.Close()
+
+	jwks := &fakeJWKS{signers: []*testSigner{{kid: "rsa", rsaKey: rsaKey, enabled: true}}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := getSigningKey(slow.URL, "rsa")
+			errs <- err
+		}()
+	}
+
+	// the keys of the other issuers are fetched while the slow endpoint is being fetched
+	done := make(chan error)
+	go func() {
+		_, err := getSigningKey(server.URL, "rsa")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("key fetch blocked by the slow JWKS endpoint")
+	}
+
+	close(release)
+	assert.NotNil(t, <-errs)
+	assert.NotNil(t, <-errs)
+}
@@ -0,0 +1,187 @@
+package providers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMaxAge is how long a fetched key set is trusted before it is fetched again
+	jwksMaxAge = 24 * time.Hour
+
+	// jwksMinRefreshInterval limits refetches triggered by unknown key ids
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type keySet struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// key sets are cached per JWKS URL and refreshed when an unknown key id shows up
+var keySetCache = struct {
+	sync.Mutex
+	sets map[string]*keySet
+}{sets: make(map[string]*keySet)}
+
+// keySetFetches runs a single fetch per JWKS URL at a time
+var keySetFetches fetchGroup
+
+// getSigningKey returns the public key with the given kid from the key set at jwksURL.
+// An unknown kid forces a refetch of the key set so that rotated keys are picked up
+func getSigningKey(jwksURL string, kid string) (crypto.PublicKey, error) {
+	keySetCache.Lock()
+	set, ok := keySetCache.sets[jwksURL]
+	keySetCache.Unlock()
+	if ok && time.Since(set.fetchedAt) < jwksMaxAge {
+		if key, ok := set.lookup(kid); ok {
+			return key, nil
+		}
+
+		if time.Since(set.fetchedAt) < jwksMinRefreshInterval {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	// the cache isn't held during the fetch so that a slow JWKS endpoint doesn't hold up the verifications
+	// of the other issuers, while the verifications waiting on the same endpoint share a single fetch
+	fetched, err := keySetFetches.do(jwksURL, func() (interface{}, error) {
+		set, err := fetchKeySet(jwksURL)
+		if err != nil {
+			return nil, err
+		}
+
+		keySetCache.Lock()
+		keySetCache.sets[jwksURL] = set
+		keySetCache.Unlock()
+		return set, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := fetched.(*keySet).lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// lookup finds the key by kid. Tokens without a kid are accepted only if the set has a single key
+func (set *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(set.keys) == 1 {
+		for _, key := range set.keys {
+			return key, true
+		}
+	}
+
+	key, ok := set.keys[kid]
+	return key, ok
+}
+
+func fetchKeySet(jwksURL string) (*keySet, error) {
+	resp, err := httpClient.Get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %d from %q", resp.StatusCode, jwksURL)
+	}
+
+	var jsonResponse struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err = json.Unmarshal(body, &jsonResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &keySet{keys: make(map[string]crypto.PublicKey), fetchedAt: time.Now()}
+	for _, jwk := range jsonResponse.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			// skip key types we don't understand instead of failing the whole set
+			continue
+		}
+		set.keys[jwk.KeyID] = key
+	}
+
+	return set, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := jwtDecodeSegment(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwtDecodeSegment(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := jwtDecodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwtDecodeSegment(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+}
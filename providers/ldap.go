@@ -0,0 +1,316 @@
+package providers
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/config"
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	// ldapTimeout bounds every request to the directory
+	ldapTimeout = 10 * time.Second
+
+	ldapDefaultUserFilter     = "(uid={username})"
+	ldapDefaultGroupFilter    = "(member={dn})"
+	ldapDefaultGroupAttribute = "cn"
+	ldapDefaultEmailAttribute = "mail"
+	ldapDefaultNameAttribute  = "cn"
+)
+
+//ErrNotLDAPGroupMember is returned when the LDAP user is not a member of any of the allowed groups
+var ErrNotLDAPGroupMember = errors.New("not a member of the allowed LDAP groups")
+
+//LDAPProvider authenticates the users of the login form with a bind against an LDAP directory or Active Directory.
+//The user is searched with the user_filter, bound as the bind_dn, and the password is checked by binding as the user.
+//The groups of the user are searched with the group_filter
+type LDAPProvider struct {
+	pData  *ProviderData
+	config config.ProviderConfig
+}
+
+//RedirectToAuthPage redirects to the login form
+func (provider *LDAPProvider) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	redirectToLoginForm(w, r, authState)
+}
+
+//RedeemCode always fails, the users login with the password
+func (provider *LDAPProvider) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	return nil, errors.New("LDAP logins have no authorization code")
+}
+
+//Authenticate binds as the user with the password and returns the tokens of the user's profile.
+//The failed logins are limited by the directory's own password policy
+func (provider *LDAPProvider) Authenticate(username, password string) (*RedeemResponse, error) {
+	// an empty password is an unauthenticated bind, which most directories accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := provider.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = provider.bindService(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := strings.Replace(provider.userFilter(), "{username}", ldap.EscapeFilter(username), -1)
+	entry, err := provider.searchUser(conn, provider.config.UserBaseDN, ldap.ScopeWholeSubtree, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the groups are searched with the service account
+	err = provider.bindService(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := provider.profile(conn, entry, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPasswordTokens(ldapSubject(entry.DN, username), profile)
+}
+
+//RefreshAccessToken looks the user up again, with the bind_dn, so that removed users and groups take effect
+func (provider *LDAPProvider) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	token, err := decodePasswordToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	dn, username := parseLDAPSubject(token.Subject)
+	conn, err := provider.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = provider.bindService(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := provider.searchUser(conn, dn, ldap.ScopeBaseObject, "(objectClass=*)")
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := provider.profile(conn, entry, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPasswordTokens(token.Subject, profile)
+}
+
+//GetProfileDataFromAccessToken gets the user profile held by the access token
+func (provider *LDAPProvider) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	return profileFromPasswordToken(accessToken)
+}
+
+//Data provides provider specific data
+func (provider *LDAPProvider) Data() *ProviderData {
+	return provider.pData
+}
+
+// dial connects to the server_url, upgrading the connection with StartTLS if enabled
+func (provider *LDAPProvider) dial() (*ldap.Conn, error) {
+	serverURL, err := url.Parse(provider.config.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := serverURL.Host
+	if serverURL.Port() == "" {
+		port := "389"
+		if serverURL.Scheme == "ldaps" {
+			port = "636"
+		}
+		host = net.JoinHostPort(serverURL.Hostname(), port)
+	}
+
+	tlsConfig := &tls.Config{ServerName: serverURL.Hostname(), InsecureSkipVerify: provider.config.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	var netConn net.Conn
+	if serverURL.Scheme == "ldaps" {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// every login dials its own connection, so the deadline bounds the whole exchange, the StartTLS handshake included
+	err = netConn.SetDeadline(time.Now().Add(ldapTimeout))
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	conn := ldap.NewConn(netConn, serverURL.Scheme == "ldaps")
+	conn.Start()
+	conn.SetTimeout(ldapTimeout)
+	if provider.config.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// bindService binds with the bind_dn
+func (provider *LDAPProvider) bindService(conn *ldap.Conn) error {
+	err := conn.Bind(provider.config.BindDN, provider.config.BindPassword)
+	if err != nil {
+		return fmt.Errorf("LDAP bind as %s failed: %v", provider.config.BindDN, err)
+	}
+
+	return nil
+}
+
+// searchUser returns the single user entry matching the filter. Missing and ambiguous users are invalid credentials
+func (provider *LDAPProvider) searchUser(conn *ldap.Conn, baseDN string, scope int, filter string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(baseDN, scope, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		filter, []string{provider.emailAttribute(), provider.nameAttribute()}, nil)
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+
+	if len(result.Entries) != 1 {
+		log.Printf("LDAP user search %s matched %d entries\n", filter, len(result.Entries))
+		return nil, ErrInvalidCredentials
+	}
+
+	return result.Entries[0], nil
+}
+
+// profile builds the profile of the user entry along with the groups, after checking the user is a member of the allowed groups
+func (provider *LDAPProvider) profile(conn *ldap.Conn, entry *ldap.Entry, username string) (*AuthResponse, error) {
+	groups, err := provider.searchGroups(conn, entry.DN, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(provider.config.Groups) > 0 && !containsAnyFold(provider.config.Groups, groups) {
+		return nil, ErrNotLDAPGroupMember
+	}
+
+	authResponse := AuthResponse{}
+	authResponse.Email = entry.GetAttributeValue(provider.emailAttribute())
+	authResponse.EmailVerified = authResponse.Email != ""
+	authResponse.Name = entry.GetAttributeValue(provider.nameAttribute())
+	authResponse.Login = username
+	authResponse.Groups = groups
+	if authResponse.Name == "" {
+		authResponse.Name = username
+	}
+
+	return &authResponse, nil
+}
+
+// searchGroups returns the group_attribute of the groups matching the group_filter under the group_base_dn
+func (provider *LDAPProvider) searchGroups(conn *ldap.Conn, dn, username string) ([]string, error) {
+	if provider.config.GroupBaseDN == "" {
+		return nil, nil
+	}
+
+	filter := provider.config.GroupFilter
+	if filter == "" {
+		filter = ldapDefaultGroupFilter
+	}
+	filter = strings.Replace(filter, "{dn}", ldap.EscapeFilter(dn), -1)
+	filter = strings.Replace(filter, "{username}", ldap.EscapeFilter(username), -1)
+
+	attribute := provider.config.GroupAttribute
+	if attribute == "" {
+		attribute = ldapDefaultGroupAttribute
+	}
+
+	request := ldap.NewSearchRequest(provider.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0,
+		int(ldapTimeout.Seconds()), false, filter, []string{attribute}, nil)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for _, group := range result.Entries {
+		if name := group.GetAttributeValue(attribute); name != "" {
+			groups = append(groups, name)
+		}
+	}
+
+	return groups, nil
+}
+
+func (provider *LDAPProvider) userFilter() string {
+	if provider.config.UserFilter == "" {
+		return ldapDefaultUserFilter
+	}
+	return provider.config.UserFilter
+}
+
+func (provider *LDAPProvider) emailAttribute() string {
+	if provider.config.EmailAttribute == "" {
+		return ldapDefaultEmailAttribute
+	}
+	return provider.config.EmailAttribute
+}
+
+func (provider *LDAPProvider) nameAttribute() string {
+	if provider.config.NameAttribute == "" {
+		return ldapDefaultNameAttribute
+	}
+	return provider.config.NameAttribute
+}
+
+// ldapSubject keeps the username the user logged in with along with the DN, which can't hold a newline
+func ldapSubject(dn, username string) string {
+	return dn + "\n" + username
+}
+
+func parseLDAPSubject(subject string) (dn, username string) {
+	parts := strings.SplitN(subject, "\n", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+//NewLDAPProvider gives new LDAP provider for the given configuration
+func NewLDAPProvider(providerConfig config.ProviderConfig) Provider {
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	return &LDAPProvider{pData: &pData, config: providerConfig}
+}
@@ -0,0 +1,152 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/providers/ldaptest"
+)
+
+func newTestDirectory() *ldaptest.Server {
+	return ldaptest.NewServer(
+		&ldaptest.Entry{DN: "cn=service,dc=example,dc=com", Password: "service secret"},
+		&ldaptest.Entry{
+			DN:       "uid=john,ou=people,dc=example,dc=com",
+			Password: "john secret",
+			Attributes: map[string][]string{
+				"uid":  {"john"},
+				"cn":   {"John Doe"},
+				"mail": {"john@example.com"},
+			},
+		},
+		&ldaptest.Entry{
+			DN:         "uid=jane,ou=people,dc=example,dc=com",
+			Password:   "jane secret",
+			Attributes: map[string][]string{"uid": {"jane"}, "cn": {"Jane Doe"}},
+		},
+		&ldaptest.Entry{
+			DN:         "uid=dup,ou=people,dc=example,dc=com",
+			Password:   "dup secret",
+			Attributes: map[string][]string{"uid": {"dup"}},
+		},
+		&ldaptest.Entry{
+			DN:         "uid=dup,ou=contractors,dc=example,dc=com",
+			Password:   "dup secret",
+			Attributes: map[string][]string{"uid": {"dup"}},
+		},
+		&ldaptest.Entry{
+			DN: "cn=admins,ou=groups,dc=example,dc=com",
+			Attributes: map[string][]string{
+				"cn":     {"admins"},
+				"member": {"uid=john,ou=people,dc=example,dc=com"},
+			},
+		},
+		&ldaptest.Entry{
+			DN: "cn=devs,ou=groups,dc=example,dc=com",
+			Attributes: map[string][]string{
+				"cn":     {"devs"},
+				"member": {"uid=john,ou=people,dc=example,dc=com", "uid=jane,ou=people,dc=example,dc=com"},
+			},
+		},
+	)
+}
+
+func newTestLDAPProvider(serverURL string, groups []string) *LDAPProvider {
+	return NewLDAPProvider(config.ProviderConfig{
+		Name:         "ldap",
+		ServerURL:    serverURL,
+		BindDN:       "cn=service,dc=example,dc=com",
+		BindPassword: "service secret",
+		UserBaseDN:   "dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+		Groups:       groups,
+	}).(*LDAPProvider)
+}
+
+func TestLDAPProvider_Authenticate(t *testing.T) {
+	directory := newTestDirectory()
+	defer directory.Close()
+
+	tests := []struct {
+		username      string
+		password      string
+		groups        []string
+		expectedError error
+	}{
+		{username: "john", password: "john secret"},
+		{username: "JOHN", password: "john secret"},
+		{username: "jane", password: "jane secret"},
+		{username: "john", password: "john secret", groups: []string{"admins"}},
+		{username: "jane", password: "jane secret", groups: []string{"admins"}, expectedError: ErrNotLDAPGroupMember},
+		{username: "john", password: "wrong", expectedError: ErrInvalidCredentials},
+		{username: "john", password: "", expectedError: ErrInvalidCredentials},
+		{username: "nobody", password: "john secret", expectedError: ErrInvalidCredentials},
+		{username: "dup", password: "dup secret", expectedError: ErrInvalidCredentials},
+		{username: "*", password: "john secret", expectedError: ErrInvalidCredentials},
+		{username: "john)(uid=*", password: "john secret", expectedError: ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		provider := newTestLDAPProvider(directory.URL, test.groups)
+		_, err := provider.Authenticate(test.username, test.password)
+		assert.Equal(t, test.expectedError, err, test.username)
+	}
+}
+
+func TestLDAPProvider_Profile(t *testing.T) {
+	directory := newTestDirectory()
+	defer directory.Close()
+
+	provider := newTestLDAPProvider(directory.URL, nil)
+	redeemResponse, err := provider.Authenticate("john", "john secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, directory.Binds(), "uid=john,ou=people,dc=example,dc=com")
+
+	authResponse, err := provider.GetProfileDataFromAccessToken(redeemResponse.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "john@example.com", authResponse.Email)
+	assert.True(t, authResponse.EmailVerified)
+	assert.Equal(t, "John Doe", authResponse.Name)
+	assert.Equal(t, "john", authResponse.Login)
+	assert.Equal(t, []string{"admins", "devs"}, authResponse.Groups)
+
+	// the refresh token doesn't hold the profile
+	_, err = provider.GetProfileDataFromAccessToken(redeemResponse.RefreshToken)
+	assert.NotNil(t, err)
+
+	// refreshing looks the user up again
+	refreshed, err := provider.RefreshAccessToken(redeemResponse.RefreshToken)
+	assert.Nil(t, err)
+	authResponse, err = provider.GetProfileDataFromAccessToken(refreshed.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "john", authResponse.Login)
+	assert.Equal(t, []string{"admins", "devs"}, authResponse.Groups)
+
+	// expired access tokens make the handler refresh
+	expired, _ := encodePasswordToken(&passwordToken{Subject: "uid=john,ou=people,dc=example,dc=com", Profile: authResponse, ExpiresAt: 1})
+	_, err = provider.GetProfileDataFromAccessToken(expired)
+	assert.NotNil(t, err)
+
+	// removed users can't refresh
+	removed, _ := encodePasswordToken(&passwordToken{Subject: ldapSubject("uid=gone,ou=people,dc=example,dc=com", "gone")})
+	_, err = provider.RefreshAccessToken(removed)
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	// users who left the allowed groups can't refresh
+	_, err = newTestLDAPProvider(directory.URL, []string{"ops"}).RefreshAccessToken(redeemResponse.RefreshToken)
+	assert.Equal(t, ErrNotLDAPGroupMember, err)
+}
+
+func TestLDAPProvider_ServiceBind(t *testing.T) {
+	directory := newTestDirectory()
+	defer directory.Close()
+
+	provider := newTestLDAPProvider(directory.URL, nil)
+	provider.config.BindPassword = "wrong"
+	_, err := provider.Authenticate("john", "john secret")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrInvalidCredentials, err)
+}
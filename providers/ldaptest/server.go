@@ -0,0 +1,242 @@
+//Package ldaptest provides an in-process LDAP server for the tests, answering the simple binds
+//and the searches of the LDAP provider from a fixed set of entries
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+)
+
+//Entry is an entry of the test directory. Entries with a Password accept simple binds
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+//Server is an LDAP server listening on the loopback interface
+type Server struct {
+	//URL of the server, ldap://127.0.0.1:port
+	URL string
+
+	listener net.Listener
+	entries  []*Entry
+
+	mu    sync.Mutex
+	binds []string
+}
+
+//NewServer starts a server serving the entries. The caller should Close it when done
+func NewServer(entries ...*Entry) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ldaptest: failed to listen: " + err.Error())
+	}
+
+	server := &Server{URL: "ldap://" + listener.Addr().String(), listener: listener, entries: entries}
+	go server.serve()
+	return server
+}
+
+//Close stops the server
+func (server *Server) Close() {
+	server.listener.Close()
+}
+
+//Binds returns the DNs of the successful binds in order
+func (server *Server) Binds() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return append([]string(nil), server.binds...)
+}
+
+func (server *Server) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		messageID := packet.Children[0].Value
+		request := packet.Children[1]
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			resultCode := server.bind(request)
+			conn.Write(response(messageID, ldap.ApplicationBindResponse, resultCode).Bytes())
+		case ldap.ApplicationSearchRequest:
+			for _, entry := range server.search(messageID, request) {
+				conn.Write(entry.Bytes())
+			}
+			conn.Write(response(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			conn.Write(response(messageID, ber.Tag(request.Tag+1), ldap.LDAPResultUnwillingToPerform).Bytes())
+		}
+	}
+}
+
+// bind checks the simple bind request. Anonymous binds are allowed
+func (server *Server) bind(request *ber.Packet) int {
+	if len(request.Children) < 3 {
+		return ldap.LDAPResultProtocolError
+	}
+
+	dn, _ := request.Children[1].Value.(string)
+	password := request.Children[2].Data.String()
+	if dn == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+
+	for _, entry := range server.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			server.mu.Lock()
+			server.binds = append(server.binds, entry.DN)
+			server.mu.Unlock()
+			return ldap.LDAPResultSuccess
+		}
+	}
+
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// search returns the search result entries of the entries under the base matching the filter
+func (server *Server) search(messageID interface{}, request *ber.Packet) []*ber.Packet {
+	if len(request.Children) < 8 {
+		return nil
+	}
+
+	base, _ := request.Children[0].Value.(string)
+	scope, _ := request.Children[1].Value.(int64)
+	filter := request.Children[6]
+	var attributes []string
+	for _, attribute := range request.Children[7].Children {
+		if name, ok := attribute.Value.(string); ok {
+			attributes = append(attributes, name)
+		}
+	}
+
+	var results []*ber.Packet
+	for _, entry := range server.entries {
+		if !inScope(entry.DN, base, scope) || !matches(entry, filter) {
+			continue
+		}
+		results = append(results, resultEntry(messageID, entry, attributes))
+	}
+
+	return results
+}
+
+func inScope(dn, base string, scope int64) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return dn == base
+	case ldap.ScopeSingleLevel:
+		i := strings.Index(dn, ",")
+		return i > 0 && dn[i+1:] == base
+	default:
+		return dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+// matches evaluates the and, or, not, equality and present filters against the entry
+func matches(entry *Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !matches(entry, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		attribute, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		for _, v := range values(entry, attribute) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(values(entry, filter.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+// values returns the values of the attribute, ignoring the case of its name. objectClass=* matches every entry
+func values(entry *Entry, attribute string) []string {
+	if strings.EqualFold(attribute, "objectClass") && len(entry.Attributes["objectClass"]) == 0 {
+		return []string{"top"}
+	}
+
+	for name, values := range entry.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+func resultEntry(messageID interface{}, entry *Entry, attributes []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	attributesPacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, name := range attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values(entry, name) {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributesPacket.AppendChild(attribute)
+	}
+	op.AppendChild(attributesPacket)
+
+	return envelope(messageID, op)
+}
+
+func response(messageID interface{}, tag ber.Tag, resultCode int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return envelope(messageID, op)
+}
+
+func envelope(messageID interface{}, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(op)
+	return packet
+}
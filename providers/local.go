@@ -0,0 +1,236 @@
+package providers
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	localDefaultMaxLoginAttempts = 5
+	localDefaultLockoutDuration  = 15 * time.Minute
+)
+
+//ErrNotLocalGroupMember is returned when the local user is not a member of any of the allowed groups
+var ErrNotLocalGroupMember = errors.New("not a member of the allowed groups")
+
+//LocalProvider authenticates the users of the login form against the bcrypt hashes of a users file,
+//either an htpasswd file or a JSON array of users with their profile. The file is loaded again when it changes.
+//After max_login_attempts failed logins of a username in a row, its logins back off for a second,
+//doubling with each further failure up to the lockout_duration, rather than being locked out,
+//so that others can slow the guessing down but can't keep the user out
+type LocalProvider struct {
+	pData  *ProviderData
+	config config.ProviderConfig
+}
+
+// localBackoff counts the failed logins of a username in a row
+type localBackoff struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// localBackoffs holds the failed logins by provider name and username
+var localBackoffs = struct {
+	sync.Mutex
+	entries map[string]*localBackoff
+}{entries: make(map[string]*localBackoff)}
+
+// localDummyHash is compared with the passwords of unknown users so that they take as long as the known ones
+var localDummyHash struct {
+	sync.Once
+	hash []byte
+}
+
+//RedirectToAuthPage redirects to the login form
+func (provider *LocalProvider) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	redirectToLoginForm(w, r, authState)
+}
+
+//RedeemCode always fails, the users login with the password
+func (provider *LocalProvider) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	return nil, errors.New("local logins have no authorization code")
+}
+
+//Authenticate checks the password against the hash of the user and returns the tokens of the user's profile
+func (provider *LocalProvider) Authenticate(username, password string) (*RedeemResponse, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	backoffKey := provider.backoffKey(username)
+	if isBackingOff(backoffKey) {
+		return nil, ErrAccountLocked
+	}
+
+	user, err := lookupLocalUser(provider.config.UsersFile, username)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := localDummyPasswordHash()
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || user == nil {
+		provider.recordFailure(backoffKey)
+		return nil, ErrInvalidCredentials
+	}
+
+	resetFailures(backoffKey)
+	profile, err := provider.profile(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPasswordTokens(user.Username, profile)
+}
+
+//RefreshAccessToken looks the user up again so that removed users and changed groups take effect
+func (provider *LocalProvider) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	token, err := decodePasswordToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := lookupLocalUser(provider.config.UsersFile, token.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	profile, err := provider.profile(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPasswordTokens(user.Username, profile)
+}
+
+//GetProfileDataFromAccessToken gets the user profile held by the access token
+func (provider *LocalProvider) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	return profileFromPasswordToken(accessToken)
+}
+
+//Data provides provider specific data
+func (provider *LocalProvider) Data() *ProviderData {
+	return provider.pData
+}
+
+// profile builds the profile of the user after checking the user is a member of the allowed groups
+func (provider *LocalProvider) profile(user *localUser) (*AuthResponse, error) {
+	if len(provider.config.Groups) > 0 && !containsAnyFold(provider.config.Groups, user.Groups) {
+		return nil, ErrNotLocalGroupMember
+	}
+
+	authResponse := AuthResponse{}
+	authResponse.Email = user.Email
+	authResponse.EmailVerified = user.Email != ""
+	authResponse.Name = user.Name
+	authResponse.Login = user.Username
+	authResponse.Groups = user.Groups
+	if authResponse.Name == "" {
+		authResponse.Name = user.Username
+	}
+
+	return &authResponse, nil
+}
+
+// isBackingOff reports whether the logins of the backoff key are held back after too many failed logins.
+// The attempts made meanwhile are rejected without being counted
+func isBackingOff(key string) bool {
+	localBackoffs.Lock()
+	defer localBackoffs.Unlock()
+	backoff, ok := localBackoffs.entries[key]
+	return ok && time.Now().Before(backoff.blockedUntil)
+}
+
+// recordFailure counts the failed login and, from the max_login_attempts on, holds the logins of the backoff key back
+// for a second doubling with each further failure up to the lockout_duration.
+// Unknown usernames are counted too, so the backoff doesn't tell which usernames exist
+func (provider *LocalProvider) recordFailure(key string) {
+	now := time.Now()
+	lockoutDuration := provider.lockoutDuration()
+
+	localBackoffs.Lock()
+	defer localBackoffs.Unlock()
+	for key, backoff := range localBackoffs.entries {
+		if now.After(backoff.blockedUntil) && now.Sub(backoff.lastFailure) > lockoutDuration {
+			delete(localBackoffs.entries, key)
+		}
+	}
+
+	backoff, ok := localBackoffs.entries[key]
+	if !ok {
+		backoff = &localBackoff{}
+		localBackoffs.entries[key] = backoff
+	}
+
+	backoff.failures++
+	backoff.lastFailure = now
+	if excess := backoff.failures - provider.maxLoginAttempts(); excess >= 0 {
+		backoff.blockedUntil = now.Add(backoffDelay(excess, lockoutDuration))
+	}
+}
+
+// backoffDelay returns a second doubled for each excess failure, up to the limit
+func backoffDelay(excess int, limit time.Duration) time.Duration {
+	delay := time.Second
+	for ; excess > 0 && delay < limit; excess-- {
+		delay *= 2
+	}
+
+	if delay > limit {
+		return limit
+	}
+	return delay
+}
+
+func resetFailures(key string) {
+	localBackoffs.Lock()
+	defer localBackoffs.Unlock()
+	delete(localBackoffs.entries, key)
+}
+
+func (provider *LocalProvider) backoffKey(username string) string {
+	return provider.pData.ProviderName + "\n" + username
+}
+
+func (provider *LocalProvider) maxLoginAttempts() int {
+	if provider.config.MaxLoginAttempts == 0 {
+		return localDefaultMaxLoginAttempts
+	}
+	return provider.config.MaxLoginAttempts
+}
+
+// lockoutDuration returns the lockout_duration, the longest backoff, validated with the config
+func (provider *LocalProvider) lockoutDuration() time.Duration {
+	duration, err := time.ParseDuration(provider.config.LockoutDuration)
+	if err != nil || duration <= 0 {
+		return localDefaultLockoutDuration
+	}
+	return duration
+}
+
+func localDummyPasswordHash() []byte {
+	localDummyHash.Do(func() {
+		localDummyHash.hash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+	return localDummyHash.hash
+}
+
+//NewLocalProvider gives new local users provider for the given configuration
+func NewLocalProvider(providerConfig config.ProviderConfig) Provider {
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	return &LocalProvider{pData: &pData, config: providerConfig}
+}
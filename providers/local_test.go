@@ -0,0 +1,250 @@
+package providers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hashPassword(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// writeUsersFile writes the users file with a modification time apart from the previous write
+func writeUsersFile(t *testing.T, path, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalProvider_Authenticate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	htpasswd := filepath.Join(dir, "users.htpasswd")
+	writeUsersFile(t, htpasswd, "# break-glass access\n\njohn:"+hashPassword(t, "john secret")+"\n", time.Now())
+	usersJSON := filepath.Join(dir, "users.json")
+	writeUsersFile(t, usersJSON, `[
+		{"username": "john", "password_hash": "`+hashPassword(t, "john secret")+`", "email": "john@example.com", "name": "John Doe", "groups": ["admins"]},
+		{"username": "jane", "password_hash": "`+hashPassword(t, "jane secret")+`", "groups": ["devs"]}
+	]`, time.Now())
+
+	tests := []struct {
+		usersFile     string
+		username      string
+		password      string
+		groups        []string
+		expectedError error
+	}{
+		{usersFile: htpasswd, username: "john", password: "john secret"},
+		{usersFile: htpasswd, username: "john", password: "wrong", expectedError: ErrInvalidCredentials},
+		{usersFile: htpasswd, username: "JOHN", password: "john secret", expectedError: ErrInvalidCredentials},
+		{usersFile: htpasswd, username: "john", password: "", expectedError: ErrInvalidCredentials},
+		{usersFile: htpasswd, username: "nobody", password: "john secret", expectedError: ErrInvalidCredentials},
+		{usersFile: usersJSON, username: "john", password: "john secret", groups: []string{"admins"}},
+		{usersFile: usersJSON, username: "jane", password: "jane secret"},
+		{usersFile: usersJSON, username: "jane", password: "jane secret", groups: []string{"admins"}, expectedError: ErrNotLocalGroupMember},
+	}
+
+	for i, test := range tests {
+		provider := NewLocalProvider(config.ProviderConfig{
+			Name: fmt.Sprintf("local_%d", i), UsersFile: test.usersFile, Groups: test.groups}).(*LocalProvider)
+		_, err := provider.Authenticate(test.username, test.password)
+		assert.Equal(t, test.expectedError, err, test.username)
+	}
+
+	provider := NewLocalProvider(config.ProviderConfig{Name: "local", UsersFile: usersJSON}).(*LocalProvider)
+	redeemResponse, err := provider.Authenticate("john", "john secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authResponse, err := provider.GetProfileDataFromAccessToken(redeemResponse.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "john@example.com", authResponse.Email)
+	assert.True(t, authResponse.EmailVerified)
+	assert.Equal(t, "John Doe", authResponse.Name)
+	assert.Equal(t, "john", authResponse.Login)
+	assert.Equal(t, []string{"admins"}, authResponse.Groups)
+
+	_, err = provider.Authenticate("john", "john secret")
+	assert.Nil(t, err)
+
+	// a missing users file fails without locking anyone out
+	provider = NewLocalProvider(config.ProviderConfig{Name: "missing", UsersFile: filepath.Join(dir, "missing")}).(*LocalProvider)
+	_, err = provider.Authenticate("john", "john secret")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrInvalidCredentials, err)
+}
+
+func TestLocalProvider_Backoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	usersFile := filepath.Join(dir, "users.htpasswd")
+	writeUsersFile(t, usersFile, "john:"+hashPassword(t, "john secret")+"\njane:"+hashPassword(t, "jane secret")+"\n", time.Now())
+	provider := NewLocalProvider(config.ProviderConfig{
+		Name: "backoff", UsersFile: usersFile, MaxLoginAttempts: 3, LockoutDuration: "1h"}).(*LocalProvider)
+
+	// a successful login starts the count again
+	for i := 0; i < 2; i++ {
+		_, err = provider.Authenticate("john", "wrong")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	_, err = provider.Authenticate("john", "john secret")
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = provider.Authenticate("john", "wrong")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+
+	// the right password doesn't get through the backoff
+	_, err = provider.Authenticate("john", "john secret")
+	assert.Equal(t, ErrAccountLocked, err)
+
+	// other users and providers don't back off
+	_, err = provider.Authenticate("jane", "jane secret")
+	assert.Nil(t, err)
+	other := NewLocalProvider(config.ProviderConfig{Name: "other", UsersFile: usersFile}).(*LocalProvider)
+	_, err = other.Authenticate("john", "john secret")
+	assert.Nil(t, err)
+
+	// each further failure doubles the backoff
+	endBackoff := func(username string) *localBackoff {
+		localBackoffs.Lock()
+		defer localBackoffs.Unlock()
+		backoff := localBackoffs.entries[provider.backoffKey(username)]
+		backoff.blockedUntil = time.Now().Add(-time.Second)
+		return backoff
+	}
+	backoff := endBackoff("john")
+	_, err = provider.Authenticate("john", "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	localBackoffs.Lock()
+	assert.Equal(t, 2*time.Second, backoff.blockedUntil.Sub(backoff.lastFailure))
+	localBackoffs.Unlock()
+
+	// unknown usernames back off alike
+	for i := 0; i < 3; i++ {
+		_, err = provider.Authenticate("nobody", "wrong")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	_, err = provider.Authenticate("nobody", "wrong")
+	assert.Equal(t, ErrAccountLocked, err)
+
+	// the user logs in once the backoff ends
+	endBackoff("john")
+	_, err = provider.Authenticate("john", "john secret")
+	assert.Nil(t, err)
+}
+
+func Test_backoffDelay(t *testing.T) {
+	cases := []struct {
+		excess int
+		delay  time.Duration
+	}{
+		{excess: 0, delay: time.Second},
+		{excess: 3, delay: 8 * time.Second},
+		{excess: 12, delay: time.Hour},
+		{excess: 1000, delay: time.Hour},
+	}
+
+	for _, test := range cases {
+		assert.Equal(t, test.delay, backoffDelay(test.excess, time.Hour), test.excess)
+	}
+}
+
+func TestLocalProvider_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	usersFile := filepath.Join(dir, "users.htpasswd")
+	modTime := time.Now().Add(-time.Hour)
+	writeUsersFile(t, usersFile, "john:"+hashPassword(t, "john secret")+"\n", modTime)
+	provider := NewLocalProvider(config.ProviderConfig{Name: "reload", UsersFile: usersFile}).(*LocalProvider)
+
+	redeemResponse, err := provider.Authenticate("john", "john secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changed passwords take effect without a restart
+	modTime = modTime.Add(time.Minute)
+	writeUsersFile(t, usersFile, "john:"+hashPassword(t, "new secret")+"\n", modTime)
+	_, err = provider.Authenticate("john", "john secret")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = provider.Authenticate("john", "new secret")
+	assert.Nil(t, err)
+
+	// a broken file keeps the users loaded before
+	modTime = modTime.Add(time.Minute)
+	writeUsersFile(t, usersFile, "john:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n", modTime)
+	_, err = provider.Authenticate("john", "new secret")
+	assert.Nil(t, err)
+
+	_, err = provider.RefreshAccessToken(redeemResponse.RefreshToken)
+	assert.Nil(t, err)
+
+	// removed users can't refresh
+	modTime = modTime.Add(time.Minute)
+	writeUsersFile(t, usersFile, "jane:"+hashPassword(t, "jane secret")+"\n", modTime)
+	_, err = provider.RefreshAccessToken(redeemResponse.RefreshToken)
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestLoadLocalUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash := hashPassword(t, "secret")
+	tests := []struct {
+		content       string
+		expectedUsers int
+		expectedError bool
+	}{
+		{content: "", expectedUsers: 0},
+		{content: "john:" + hash + "\n# comment\njane:" + hash, expectedUsers: 2},
+		{content: `[{"username": "john", "password_hash": "` + hash + `"}]`, expectedUsers: 1},
+		{content: "john:" + hash + "\njohn:" + hash, expectedError: true},
+		{content: "john", expectedError: true},
+		{content: "john:$apr1$salt$hash", expectedError: true},
+		{content: "john:plain", expectedError: true},
+		{content: `[{"password_hash": "` + hash + `"}]`, expectedError: true},
+		{content: `[{"username": "john"`, expectedError: true},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, "users")
+		writeUsersFile(t, path, test.content, time.Now())
+		users, err := loadLocalUsers(path)
+		assert.Equal(t, test.expectedError, err != nil, test.content)
+		assert.Equal(t, test.expectedUsers, len(users), test.content)
+	}
+}
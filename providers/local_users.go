@@ -0,0 +1,140 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// localUser is a user of the users file
+type localUser struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"`
+	Email        string   `json:"email"`
+	Name         string   `json:"name"`
+	Groups       []string `json:"groups"`
+}
+
+// localUsersFile is the loaded users file along with the modification time and size it was loaded at
+type localUsersFile struct {
+	users   map[string]*localUser
+	modTime time.Time
+	size    int64
+}
+
+// localUsersFiles holds the loaded users files by path
+var localUsersFiles = struct {
+	sync.Mutex
+	files map[string]*localUsersFile
+}{files: make(map[string]*localUsersFile)}
+
+// lookupLocalUser returns the user of the users file, or nil if there is none.
+// The file is loaded again whenever its modification time or size changes. A file that fails to
+// load again is logged and the users loaded before are kept, so a bad edit doesn't lock everyone out
+func lookupLocalUser(path, username string) (*localUser, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	localUsersFiles.Lock()
+	defer localUsersFiles.Unlock()
+	file, ok := localUsersFiles.files[path]
+	if !ok || !file.modTime.Equal(info.ModTime()) || file.size != info.Size() {
+		users, err := loadLocalUsers(path)
+		if err != nil && !ok {
+			return nil, err
+		}
+
+		if err != nil {
+			log.Printf("failed to reload the users file %s, keeping the users loaded before: %v\n", path, err)
+			file = &localUsersFile{users: file.users}
+		} else {
+			log.Printf("loaded %d users from %s\n", len(users), path)
+			file = &localUsersFile{users: users}
+		}
+
+		// the file isn't retried until it changes again
+		file.modTime, file.size = info.ModTime(), info.Size()
+		localUsersFiles.files[path] = file
+	}
+
+	return file.users[username], nil
+}
+
+// loadLocalUsers loads a JSON array of users, or an htpasswd file of username:hash lines
+func loadLocalUsers(path string) (map[string]*localUser, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*localUser
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &users)
+	} else {
+		users, err = parseHtpasswd(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	byUsername := make(map[string]*localUser, len(users))
+	for _, user := range users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("%s: user without a username", path)
+		}
+
+		if _, ok := byUsername[user.Username]; ok {
+			return nil, fmt.Errorf("%s: duplicate user %s", path, user.Username)
+		}
+
+		if !isBcryptHash(user.PasswordHash) {
+			return nil, fmt.Errorf("%s: password of user %s is not a bcrypt hash", path, user.Username)
+		}
+
+		byUsername[user.Username] = user
+	}
+
+	return byUsername, nil
+}
+
+// parseHtpasswd parses the username:hash lines of an htpasswd file, skipping the blank lines and # comments
+func parseHtpasswd(data []byte) ([]*localUser, error) {
+	var users []*localUser
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d is not username:hash", line)
+		}
+
+		users = append(users, &localUser{Username: parts[0], PasswordHash: parts[1]})
+	}
+
+	return users, scanner.Err()
+}
+
+// isBcryptHash reports whether the hash is a bcrypt hash, as written by htpasswd -B
+func isBcryptHash(hash string) bool {
+	if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+		return false
+	}
+
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
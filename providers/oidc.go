@@ -0,0 +1,294 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+//OIDCProvider for generic OpenID Connect Authentication
+type OIDCProvider struct {
+	pData  *ProviderData
+	config config.ProviderConfig
+}
+
+type oidcDiscoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+}
+
+// discovered documents are cached per issuer so that every request doesn't hit the issuer
+var discoveryCache = struct {
+	sync.Mutex
+	documents map[string]*oidcDiscoveryDocument
+}{documents: make(map[string]*oidcDiscoveryDocument)}
+
+// discoveryFetches runs a single discovery per issuer at a time
+var discoveryFetches fetchGroup
+
+//RedirectToAuthPage redirects to the issuer's authorization endpoint
+func (provider *OIDCProvider) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	if err := provider.discover(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authURL := *provider.pData.LoginURL
+	params, _ := url.ParseQuery(authURL.RawQuery)
+	params.Set("response_type", "code")
+	params.Set("scope", provider.scope())
+	params.Set("client_id", provider.config.ClientID)
+	params.Set("redirect_uri", GetAuthCallBackURL(r))
+	authState.setAuthParams(params, true)
+	authURL.RawQuery = params.Encode()
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+//RefreshAccessToken fetch new access token using the offline refresh token
+func (provider *OIDCProvider) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("refresh_token", refreshToken)
+	params.Set("grant_type", "refresh_token")
+
+	redeemResponse, err := provider.requestToken(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if redeemResponse.RefreshToken == "" {
+		redeemResponse.RefreshToken = refreshToken
+	}
+	return redeemResponse, nil
+}
+
+//RedeemCode gets access token and refresh token using the code provided
+func (provider *OIDCProvider) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("redirect_uri", redirectURL)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+	authState.setRedeemParams(params)
+	return provider.requestToken(params)
+}
+
+func (provider *OIDCProvider) requestToken(params url.Values) (*RedeemResponse, error) {
+	if err := provider.discover(); err != nil {
+		return nil, err
+	}
+
+	return requestToken(provider.pData.RedeemURL, provider.config.ClientID, provider.config.ClientSecret, authInParams, params)
+}
+
+//GetProfileDataFromAccessToken gets user profile from the issuer's userinfo endpoint
+func (provider *OIDCProvider) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	if err := provider.discover(); err != nil {
+		return nil, err
+	}
+
+	if provider.pData.ProfileURL == nil {
+		return nil, errors.New("Userinfo URL missing in provider")
+	}
+
+	req, err := http.NewRequest("GET", provider.pData.ProfileURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Validate token failed")
+	}
+
+	var jsonResponse struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+		Picture           string `json:"picture"`
+	}
+
+	err = json.Unmarshal(body, &jsonResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	authResponse := AuthResponse{}
+	authResponse.Email = jsonResponse.Email
+	authResponse.EmailVerified = jsonResponse.EmailVerified
+	authResponse.Name = jsonResponse.Name
+	authResponse.Login = jsonResponse.PreferredUsername
+	authResponse.Picture = jsonResponse.Picture
+	if authResponse.Name == "" {
+		authResponse.Name = jsonResponse.PreferredUsername
+	}
+
+	return &authResponse, nil
+}
+
+//RevokeToken revokes the token at the issuer's revocation endpoint if it has one
+func (provider *OIDCProvider) RevokeToken(token string) error {
+	if err := provider.discover(); err != nil {
+		return err
+	}
+
+	if provider.pData.RevokeURL == nil {
+		return errors.New("Revocation URL missing in provider")
+	}
+
+	params := url.Values{}
+	params.Set("token", token)
+	params.Set("client_id", provider.config.ClientID)
+	params.Set("client_secret", provider.config.ClientSecret)
+	return revokeToken(provider.pData.RevokeURL, params)
+}
+
+//Data provides provider specific data
+func (provider *OIDCProvider) Data() *ProviderData {
+	return provider.pData
+}
+
+func (provider *OIDCProvider) scope() string {
+	if provider.config.AuthScope == "" {
+		return "openid profile email"
+	}
+	return provider.config.AuthScope
+}
+
+// discover fills the provider endpoints from the issuer's discovery document
+func (provider *OIDCProvider) discover() error {
+	if provider.pData.LoginURL != nil {
+		return nil
+	}
+
+	document, err := getDiscoveryDocument(provider.config.IssuerURL)
+	if err != nil {
+		return err
+	}
+
+	pData := provider.pData
+	pData.Issuer = document.Issuer
+	if pData.LoginURL, err = url.Parse(document.AuthorizationEndpoint); err != nil {
+		return err
+	}
+	if pData.RedeemURL, err = url.Parse(document.TokenEndpoint); err != nil {
+		return err
+	}
+	if document.UserinfoEndpoint != "" {
+		if pData.ProfileURL, err = url.Parse(document.UserinfoEndpoint); err != nil {
+			return err
+		}
+	}
+	if document.RevocationEndpoint != "" {
+		if pData.RevokeURL, err = url.Parse(document.RevocationEndpoint); err != nil {
+			return err
+		}
+	}
+	if document.JWKSURI != "" {
+		if pData.JWKSURL, err = url.Parse(document.JWKSURI); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getDiscoveryDocument(issuerURL string) (*oidcDiscoveryDocument, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+	if issuerURL == "" {
+		return nil, errors.New("Issuer URL missing in provider")
+	}
+
+	discoveryCache.Lock()
+	document, ok := discoveryCache.documents[issuerURL]
+	discoveryCache.Unlock()
+	if ok {
+		return document, nil
+	}
+
+	// the cache isn't held during the fetch so that a slow issuer doesn't hold up the other providers
+	fetched, err := discoveryFetches.do(issuerURL, func() (interface{}, error) {
+		return fetchDiscoveryDocument(issuerURL)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	document = fetched.(*oidcDiscoveryDocument)
+	discoveryCache.Lock()
+	discoveryCache.documents[issuerURL] = document
+	discoveryCache.Unlock()
+	return document, nil
+}
+
+func fetchDiscoveryDocument(issuerURL string) (*oidcDiscoveryDocument, error) {
+	resp, err := httpClient.Get(issuerURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %d from %q discovery", resp.StatusCode, issuerURL)
+	}
+
+	document := &oidcDiscoveryDocument{}
+	err = json.Unmarshal(body, document)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(document.Issuer, "/") != issuerURL {
+		return nil, fmt.Errorf("issuer %q in discovery document doesn't match %q", document.Issuer, issuerURL)
+	}
+
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" {
+		return nil, errors.New("discovery document is missing authorization or token endpoint")
+	}
+
+	return document, nil
+}
+
+//NewOIDCProvider gives new OpenID Connect provider for the given configuration.
+//Endpoints are discovered from the issuer on first use
+func NewOIDCProvider(providerConfig config.ProviderConfig) Provider {
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	pData.ClientID = providerConfig.ClientID
+	return &OIDCProvider{pData: &pData, config: providerConfig}
+}
@@ -0,0 +1,230 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+func newFakeIssuer() *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+			"jwks_uri":               server.URL + "/keys",
+			"revocation_endpoint":    server.URL + "/revoke",
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_id") != "client" || r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			if r.Form.Get("code") != "valid_code" || r.Form.Get("code_verifier") != "valid_verifier" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "valid_token",
+				"refresh_token": "refresh_token",
+				"expires_in":    3600,
+			})
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh_token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": "refreshed_token",
+				"expires_in":   3600,
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_id") != "client" || r.Form.Get("token") != "refresh_token" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer valid_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"email":              "john@example.com",
+			"email_verified":     true,
+			"preferred_username": "john",
+		})
+	})
+
+	return server
+}
+
+func newTestOIDCProvider(issuerURL string) Provider {
+	return NewOIDCProvider(config.ProviderConfig{
+		Name:         "keycloak",
+		Type:         "oidc",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		IssuerURL:    issuerURL,
+	})
+}
+
+func TestOIDCProvider_RedirectToAuthPage(t *testing.T) {
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	provider := newTestOIDCProvider(issuer.URL)
+	w := httptest.NewRecorder()
+	r := &http.Request{Host: "localhost:8080", URL: &url.URL{Scheme: ""}}
+	provider.RedirectToAuthPage(w, r, &AuthState{State: "keycloak||token", CodeVerifier: "valid_verifier", Nonce: "nonce"})
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/authorize", location.Path)
+	assert.Equal(t, "client", location.Query().Get("client_id"))
+	assert.Equal(t, "keycloak||token", location.Query().Get("state"))
+	assert.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+	assert.Equal(t, "nonce", location.Query().Get("nonce"))
+	// S256 of valid_verifier
+	assert.Equal(t, "14SQ7N2osNSe3vnNaD0VX5cuNAdrz5iXHTum2kKhpbI", location.Query().Get("code_challenge"))
+	assert.Equal(t, "openid profile email", location.Query().Get("scope"))
+	assert.Equal(t, "http://localhost:8080/oauth2/callback", location.Query().Get("redirect_uri"))
+	assert.Equal(t, issuer.URL+"/keys", provider.Data().JWKSURL.String())
+}
+
+func TestOIDCProvider_RedeemCode(t *testing.T) {
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	tests := []struct {
+		code                string
+		codeVerifier        string
+		expectedAccessToken string
+		expectError         bool
+	}{
+		{code: "valid_code", codeVerifier: "valid_verifier", expectedAccessToken: "valid_token"},
+		{code: "valid_code", codeVerifier: "other_verifier", expectError: true},
+		{code: "invalid_code", codeVerifier: "valid_verifier", expectError: true},
+	}
+
+	provider := newTestOIDCProvider(issuer.URL)
+	for _, test := range tests {
+		authState := &AuthState{CodeVerifier: test.codeVerifier}
+		response, err := provider.RedeemCode(test.code, "http://localhost:8080/oauth2/callback", authState)
+		if test.expectError {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, test.expectedAccessToken, response.AccessToken)
+		assert.Equal(t, "refresh_token", response.RefreshToken)
+	}
+}
+
+func TestOIDCProvider_RefreshAccessToken(t *testing.T) {
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	provider := newTestOIDCProvider(issuer.URL)
+	response, err := provider.RefreshAccessToken("refresh_token")
+	assert.Nil(t, err)
+	assert.Equal(t, "refreshed_token", response.AccessToken)
+	assert.Equal(t, "refresh_token", response.RefreshToken)
+
+	_, err = provider.RefreshAccessToken("unknown")
+	assert.NotNil(t, err)
+}
+
+func TestOIDCProvider_GetProfileDataFromAccessToken(t *testing.T) {
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	tests := []struct {
+		accessToken      string
+		expectedResponse *AuthResponse
+	}{
+		{accessToken: "valid_token", expectedResponse: &AuthResponse{Name: "john", Login: "john", Email: "john@example.com", EmailVerified: true}},
+		{accessToken: "invalid_token", expectedResponse: nil},
+	}
+
+	provider := newTestOIDCProvider(issuer.URL)
+	for _, test := range tests {
+		response, _ := provider.GetProfileDataFromAccessToken(test.accessToken)
+		assert.Equal(t, test.expectedResponse, response)
+	}
+}
+
+func TestOIDCProvider_RevokeToken(t *testing.T) {
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	provider := newTestOIDCProvider(issuer.URL).(Revoker)
+	assert.Nil(t, provider.RevokeToken("refresh_token"))
+	assert.NotNil(t, provider.RevokeToken("unknown"))
+}
+
+func TestOIDCProvider_DiscoveryFailure(t *testing.T) {
+	provider := newTestOIDCProvider("")
+	_, err := provider.RedeemCode("valid_code", "", &AuthState{})
+	assert.NotNil(t, err)
+}
+
+func TestGetDiscoveryDocument_SlowIssuer(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer slow.Close()
+
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := getDiscoveryDocument(slow.URL)
+			errs <- err
+		}()
+	}
+
+	// the other issuers are discovered while the slow one is being fetched
+	done := make(chan error)
+	go func() {
+		_, err := getDiscoveryDocument(issuer.URL)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("discovery blocked by the slow issuer")
+	}
+
+	close(release)
+	assert.NotNil(t, <-errs)
+	assert.NotNil(t, <-errs)
+}
@@ -0,0 +1,100 @@
+package providers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// passwordRecheckInterval is how long the profile of a password login is trusted before
+// the user is looked up again through RefreshAccessToken
+const passwordRecheckInterval = 5 * time.Minute
+
+//ErrInvalidCredentials is returned when the username or the password is wrong. It doesn't tell which
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+//ErrAccountLocked is returned while the logins of the username back off after too many failed logins
+var ErrAccountLocked = errors.New("too many failed logins")
+
+//PasswordProvider is implemented by the providers authenticating the users with the username and password
+//sent to the login form, /oauth2/login, rather than redirecting them to an authorization server.
+//The tokens returned are used with the provider like those redeemed from a code
+type PasswordProvider interface {
+	Authenticate(username, password string) (*RedeemResponse, error)
+}
+
+// passwordToken is the access and refresh token of the password providers. The tokens only live in the
+// session, whose cookie is signed, so the access token carries the profile rather than referencing it.
+// The refresh token only carries the subject, which is looked up again on refresh
+type passwordToken struct {
+	Subject   string        `json:"sub"`
+	Profile   *AuthResponse `json:"profile,omitempty"`
+	ExpiresAt int64         `json:"exp,omitempty"`
+}
+
+// newPasswordTokens returns the access token holding the profile and the refresh token of the subject
+func newPasswordTokens(subject string, profile *AuthResponse) (*RedeemResponse, error) {
+	expiresOn := time.Now().Add(passwordRecheckInterval).Truncate(time.Second)
+	accessToken, err := encodePasswordToken(&passwordToken{Subject: subject, Profile: profile, ExpiresAt: expiresOn.Unix()})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := encodePasswordToken(&passwordToken{Subject: subject})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedeemResponse{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresOn: expiresOn}, nil
+}
+
+func encodePasswordToken(token *passwordToken) (string, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodePasswordToken(encoded string) (*passwordToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &passwordToken{}
+	err = json.Unmarshal(data, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Subject == "" {
+		return nil, errors.New("token subject missing")
+	}
+
+	return token, nil
+}
+
+// profileFromPasswordToken returns the profile of the access token until it expires
+func profileFromPasswordToken(accessToken string) (*AuthResponse, error) {
+	token, err := decodePasswordToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Profile == nil || time.Now().Unix() >= token.ExpiresAt {
+		return nil, errors.New("access token expired")
+	}
+
+	return token.Profile, nil
+}
+
+// redirectToLoginForm sends the user to the login form of the password providers with the state of the login
+func redirectToLoginForm(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	loginURL := url.URL{Path: "/oauth2/login", RawQuery: url.Values{"state": {authState.State}}.Encode()}
+	http.Redirect(w, r, loginURL.String(), http.StatusFound)
+}
@@ -1,6 +1,9 @@
 package providers
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"time"
@@ -11,17 +14,27 @@ import (
 //Provider interface for every provider available
 type Provider interface {
 	Data() *ProviderData
-	RedirectToAuthPage(http.ResponseWriter, *http.Request, string)
-	RedeemCode(string, string, string) (*RedeemResponse, error)
+	RedirectToAuthPage(http.ResponseWriter, *http.Request, *AuthState)
+	RedeemCode(string, string, *AuthState) (*RedeemResponse, error)
 	GetProfileDataFromAccessToken(string) (*AuthResponse, error)
 	RefreshAccessToken(string) (*RedeemResponse, error)
 }
 
+//Revoker is implemented by providers which can revoke the tokens they issued
+type Revoker interface {
+	RevokeToken(string) error
+}
+
 //AuthResponse holds the data of a User after successful Authorization
 type AuthResponse struct {
-	Name          string `json:"name"`
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"email_verified"`
+	Name          string   `json:"name"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Provider      string   `json:"provider"`
+	Login         string   `json:"login,omitempty"`
+	Picture       string   `json:"picture,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
 }
 
 //RedeemResponse holds the response after Redeeming the code provided by the Provider
@@ -30,6 +43,10 @@ type RedeemResponse struct {
 	RefreshToken string    `json:"refresh_token"`
 	ExpiresOn    time.Time `json:"time"`
 	IDToken      string    `json:"id_token"`
+
+	// Profile is set by the providers that can't fetch the profile with the access token.
+	// It is kept in the session along with the tokens and trusted until ExpiresOn
+	Profile *AuthResponse `json:"profile,omitempty"`
 }
 
 //ProviderData holds data for specific providers
@@ -39,8 +56,16 @@ type ProviderData struct {
 	RedeemURL    *url.URL
 	ValidateURL  *url.URL
 	ProfileURL   *url.URL
+	JWKSURL      *url.URL
+	RevokeURL    *url.URL
+	Issuer       string
+	ClientID     string
+	HostedDomain string
 }
 
+// httpClient is used for every request to the providers, so that a hung provider fails the login instead of holding it up
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
 //GetAuthCallBackURL return back the auth callback url registered with the Provider
 func GetAuthCallBackURL(r *http.Request) string {
 	authCallBackURL := url.URL{}
@@ -48,7 +73,7 @@ func GetAuthCallBackURL(r *http.Request) string {
 	authCallBackURL.Host = r.Host
 	authCallBackURL.Path = "/oauth2/callback"
 	if authCallBackURL.Scheme == "" {
-		if config.Config.IsSecure() {
+		if r.TLS != nil {
 			authCallBackURL.Scheme = "https"
 		} else {
 			authCallBackURL.Scheme = "http"
@@ -57,14 +82,70 @@ func GetAuthCallBackURL(r *http.Request) string {
 	return authCallBackURL.String()
 }
 
-//GetProvider returns appropriate Provider object
-func GetProvider(providerName string) Provider {
+// revokeToken posts the token to the revocation endpoint as described in RFC 7009
+func revokeToken(revokeURL *url.URL, params url.Values) error {
+	req, err := http.NewRequest("POST", revokeURL.String(), bytes.NewBufferString(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d from %q %s", resp.StatusCode, revokeURL.String(), body)
+	}
+
+	return nil
+}
+
+//GetProvider returns appropriate Provider object of the configuration
+func GetProvider(c *config.Configuration, providerName string) Provider {
 	switch providerName {
 	case "google":
-		return NewGoogleProvider()
+		return NewGoogleProvider(c)
+	case "github":
+		return NewGitHubProvider(c)
+	}
+
+	providerConfig, ok := c.GetProviderConfig(providerName)
+	if !ok {
+		return NewGoogleProvider(c)
+	}
+
+	switch providerConfig.Type {
+	case "oidc":
+		return NewOIDCProvider(providerConfig)
 	case "github":
-		return NewGitHubProvider()
+		return NewGitHubProviderFromConfig(providerConfig)
+	case "gitlab":
+		return NewGitLabProvider(providerConfig)
+	case "azure":
+		return NewAzureProvider(providerConfig)
+	case "bitbucket":
+		return NewBitbucketProvider(providerConfig)
+	case "slack":
+		return NewSlackProvider(providerConfig)
+	case "discord":
+		return NewDiscordProvider(providerConfig)
+	case "ldap":
+		return NewLDAPProvider(providerConfig)
+	case "local":
+		return NewLocalProvider(providerConfig)
 	default:
-		return NewGoogleProvider()
+		return NewGoogleProvider(c)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
 )
 
 func TestGetProvider(t *testing.T) {
@@ -13,12 +14,12 @@ func TestGetProvider(t *testing.T) {
 		providerName   string
 		expectedResult Provider
 	}{
-		{providerName: "google", expectedResult: NewGoogleProvider()},
-		{providerName: "no_provider", expectedResult: NewGoogleProvider()},
+		{providerName: "google", expectedResult: NewGoogleProvider(&config.Config)},
+		{providerName: "no_provider", expectedResult: NewGoogleProvider(&config.Config)},
 	}
 
 	for _, test := range cases {
-		result := GetProvider(test.providerName)
+		result := GetProvider(&config.Config, test.providerName)
 		assert.Equal(t, result, test.expectedResult)
 	}
 }
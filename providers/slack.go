@@ -0,0 +1,133 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+//ErrNotSlackTeamMember is returned when the Slack user signed in to a workspace other than the allowed team
+var ErrNotSlackTeamMember = errors.New("not a member of the allowed Slack team")
+
+// slackProfiles caches the profiles by the access token
+var slackProfiles = newProfileCache()
+
+//Slack for Sign in with Slack Authentication
+type Slack struct {
+	pData  *ProviderData
+	config config.ProviderConfig
+}
+
+//RedirectToAuthPage redirects to Slack Auth page. The team_id, if configured, skips the workspace picker
+func (provider *Slack) RedirectToAuthPage(w http.ResponseWriter, r *http.Request, authState *AuthState) {
+	authURL := *provider.pData.LoginURL
+	params, _ := url.ParseQuery(authURL.RawQuery)
+	params.Set("response_type", "code")
+	params.Set("scope", provider.config.AuthScope)
+	params.Set("client_id", provider.config.ClientID)
+	params.Set("redirect_uri", GetAuthCallBackURL(r))
+	authState.setAuthParams(params, false)
+	if provider.config.TeamID != "" {
+		params.Set("team", provider.config.TeamID)
+	}
+	authURL.RawQuery = params.Encode()
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+//RefreshAccessToken fetch new access token using the offline refresh token
+func (provider *Slack) RefreshAccessToken(refreshToken string) (*RedeemResponse, error) {
+	log.Println("no refresh token model for Slack")
+	return nil, errors.New("No refresh token model for Slack")
+}
+
+//RedeemCode gets access token using the code provided
+func (provider *Slack) RedeemCode(code string, redirectURL string, authState *AuthState) (*RedeemResponse, error) {
+	params := url.Values{}
+	params.Set("redirect_uri", redirectURL)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+	authState.setRedeemParams(params)
+
+	redeemResponse, err := requestToken(provider.pData.RedeemURL, provider.config.ClientID, provider.config.ClientSecret, authInHeader, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// the team is only in the userInfo response, so the profile always comes from there
+	redeemResponse.IDToken = ""
+	return redeemResponse, nil
+}
+
+//GetProfileDataFromAccessToken gets user profile from the Slack userInfo endpoint
+//after checking the user signed in to the allowed team
+func (provider *Slack) GetProfileDataFromAccessToken(accessToken string) (*AuthResponse, error) {
+	if authResponse, ok := slackProfiles.get(provider.pData.ProviderName, accessToken); ok {
+		return authResponse, nil
+	}
+
+	var jsonResponse struct {
+		Ok            bool   `json:"ok"`
+		Error         string `json:"error"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+		UserID        string `json:"https://slack.com/user_id"`
+		TeamID        string `json:"https://slack.com/team_id"`
+	}
+	err := getJSON(provider.pData.ProfileURL.String(), accessToken, &jsonResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// Slack reports the errors with a 200
+	if !jsonResponse.Ok {
+		return nil, fmt.Errorf("got %q from %q", jsonResponse.Error, provider.pData.ProfileURL.String())
+	}
+
+	if provider.config.TeamID != "" && provider.config.TeamID != jsonResponse.TeamID {
+		return nil, ErrNotSlackTeamMember
+	}
+
+	authResponse := AuthResponse{}
+	authResponse.Email = jsonResponse.Email
+	authResponse.EmailVerified = jsonResponse.EmailVerified
+	authResponse.Name = jsonResponse.Name
+	authResponse.Login = jsonResponse.UserID
+	authResponse.Picture = jsonResponse.Picture
+
+	slackProfiles.put(provider.pData.ProviderName, accessToken, &authResponse, time.Now().Add(apiProfileTTL))
+	return &authResponse, nil
+}
+
+//Data provides provider specific data
+func (provider *Slack) Data() *ProviderData {
+	return provider.pData
+}
+
+//NewSlackProvider gives new Sign in with Slack provider for the named provider
+func NewSlackProvider(providerConfig config.ProviderConfig) Provider {
+	if providerConfig.AuthScope == "" {
+		providerConfig.AuthScope = "openid profile email"
+	}
+
+	pData := ProviderData{}
+	pData.ProviderName = providerConfig.Name
+	pData.ClientID = providerConfig.ClientID
+	pData.LoginURL = &url.URL{Scheme: "https",
+		Host: "slack.com",
+		Path: "/openid/connect/authorize"}
+	pData.RedeemURL = &url.URL{Scheme: "https",
+		Host: "slack.com",
+		Path: "/api/openid.connect.token"}
+	pData.ProfileURL = &url.URL{Scheme: "https",
+		Host: "slack.com",
+		Path: "/api/openid.connect.userInfo"}
+
+	return &Slack{pData: &pData, config: providerConfig}
+}
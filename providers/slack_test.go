@@ -0,0 +1,108 @@
+package providers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+// recorded Sign in with Slack userInfo response
+const slackUserInfo = `{
+	"ok": true,
+	"sub": "U0R7JM",
+	"https://slack.com/user_id": "U0R7JM",
+	"https://slack.com/team_id": "T0R7GR",
+	"email": "krane@slack-corp.com",
+	"email_verified": true,
+	"date_email_verified": 1622128723,
+	"name": "krane",
+	"picture": "https://secure.gravatar.com/avatar/krane.png",
+	"given_name": "Bront",
+	"family_name": "Labradoodle",
+	"locale": "en-US",
+	"https://slack.com/team_name": "kraneflannel",
+	"https://slack.com/team_domain": "kraneflannel"
+}`
+
+func newTestSlackProvider(apiURL string, teamID string) *Slack {
+	provider := NewSlackProvider(config.ProviderConfig{
+		Name:         "slack",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		TeamID:       teamID,
+	}).(*Slack)
+	provider.pData.RedeemURL, _ = url.Parse(apiURL + "/token")
+	provider.pData.ProfileURL, _ = url.Parse(apiURL + "/api/openid.connect.userInfo")
+	return provider
+}
+
+func TestSlack_RedirectToAuthPage(t *testing.T) {
+	provider := NewSlackProvider(config.ProviderConfig{Name: "slack", ClientID: "client", TeamID: "T0R7GR"})
+	r := httptest.NewRequest("GET", "http://sso.example.com/oauth2/start", nil)
+	w := httptest.NewRecorder()
+	provider.RedirectToAuthPage(w, r, &AuthState{State: "state"})
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "slack.com", location.Host)
+	assert.Equal(t, "openid profile email", location.Query().Get("scope"))
+	assert.Equal(t, "T0R7GR", location.Query().Get("team"))
+}
+
+func TestSlack_GetProfileDataFromAccessToken(t *testing.T) {
+	server := newRecordedAPI(map[string]string{"/api/openid.connect.userInfo": slackUserInfo})
+	defer server.Close()
+
+	tests := []struct {
+		teamID      string
+		expectError bool
+	}{
+		{},
+		{teamID: "T0R7GR"},
+		{teamID: "T0OTHER", expectError: true},
+	}
+
+	for _, test := range tests {
+		provider := newTestSlackProvider(server.URL, test.teamID)
+		slackProfiles = newProfileCache()
+
+		authResponse, err := provider.GetProfileDataFromAccessToken("valid_token")
+		if test.expectError {
+			assert.Equal(t, ErrNotSlackTeamMember, err)
+			continue
+		}
+
+		if assert.Nil(t, err) {
+			assert.Equal(t, "krane@slack-corp.com", authResponse.Email)
+			assert.True(t, authResponse.EmailVerified)
+			assert.Equal(t, "krane", authResponse.Name)
+			assert.Equal(t, "U0R7JM", authResponse.Login)
+		}
+	}
+
+	// Slack reports the invalid tokens with a 200
+	invalid := newRecordedAPI(map[string]string{"/api/openid.connect.userInfo": `{"ok":false,"error":"invalid_auth"}`})
+	defer invalid.Close()
+	_, err := newTestSlackProvider(invalid.URL, "").GetProfileDataFromAccessToken("valid_token")
+	assert.NotNil(t, err)
+}
+
+func TestSlack_RedeemCode(t *testing.T) {
+	server := newRecordedAPI(nil)
+	defer server.Close()
+	provider := newTestSlackProvider(server.URL, "")
+
+	redeemResponse, err := provider.RedeemCode("valid_code", "http://sso.example.com/oauth2/callback", &AuthState{State: "state"})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid_token", redeemResponse.AccessToken)
+	assert.Empty(t, redeemResponse.IDToken)
+
+	_, err = provider.RefreshAccessToken("refresh_token")
+	assert.NotNil(t, err)
+}
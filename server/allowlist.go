@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+// isAllowedRedirect checks the redirect url against the allowed_redirects patterns.
+// A pattern is of the form scheme://host/path where host may start with "*." to allow
+// any subdomain and path is a prefix the redirect path must start with
+func isAllowedRedirect(c *config.Configuration, redirectURL *url.URL) bool {
+	return matchesAnyPattern(redirectURL, c.AllowedRedirects)
+}
+
+// isAllowedOrigin checks the CORS origin against the allowed_origins patterns
+func isAllowedOrigin(c *config.Configuration, origin string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Path != "" {
+		return false
+	}
+	return matchesAnyPattern(originURL, c.AllowedOrigins)
+}
+
+// isAllowedFormOrigin checks the Origin browsers send with the form posts is oauth central itself or one of
+// the allowed_origins. The old browsers not sending an Origin are checked by the origin of the Referer,
+// the requests with neither are rejected
+func isAllowedFormOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		refererURL, err := url.Parse(r.Referer())
+		if err != nil || refererURL.Scheme == "" || refererURL.Host == "" {
+			return false
+		}
+		origin = refererURL.Scheme + "://" + refererURL.Host
+	}
+
+	originURL, err := url.Parse(origin)
+	if err == nil && originURL.Host != "" && strings.EqualFold(originURL.Host, r.Host) {
+		return true
+	}
+
+	return isAllowedOrigin(requestSnapshot(r).Config, origin)
+}
+
+func matchesAnyPattern(target *url.URL, patterns []string) bool {
+	if target.Scheme == "" || target.Host == "" || target.User != nil {
+		return false
+	}
+
+	for _, rawPattern := range patterns {
+		pattern, err := url.Parse(rawPattern)
+		if err != nil {
+			continue
+		}
+
+		if matchesPattern(target, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesPattern(target *url.URL, pattern *url.URL) bool {
+	if !strings.EqualFold(target.Scheme, pattern.Scheme) {
+		return false
+	}
+
+	if !matchesHost(strings.ToLower(target.Host), strings.ToLower(pattern.Host)) {
+		return false
+	}
+
+	return isCleanPath(target.Path) && matchesPathPrefix(target.Path, pattern.Path)
+}
+
+func matchesHost(host, pattern string) bool {
+	if !strings.HasPrefix(pattern, "*.") {
+		return host == pattern
+	}
+
+	suffix := pattern[1:]
+	return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+}
+
+// isCleanPath rejects the paths with dot segments, empty segments or backslashes. Browsers resolve those,
+// even percent-encoded, to a path other than the one matched, ex: /sso/%2e%2e/admin is /admin
+func isCleanPath(targetPath string) bool {
+	if targetPath == "" || targetPath == "/" {
+		return true
+	}
+
+	if strings.Contains(targetPath, "\\") {
+		return false
+	}
+
+	return path.Clean(targetPath) == strings.TrimSuffix(targetPath, "/")
+}
+
+func matchesPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
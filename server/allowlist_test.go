@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+func TestIsAllowedRedirect(t *testing.T) {
+	config.Config.AllowedRedirects = []string{
+		"https://app.example.com",
+		"https://*.example.org/sso/",
+		"http://localhost:3000/callback",
+	}
+	defer func() { config.Config.AllowedRedirects = nil }()
+
+	tests := []struct {
+		redirectURL    string
+		expectedResult bool
+	}{
+		{redirectURL: "https://app.example.com", expectedResult: true},
+		{redirectURL: "https://app.example.com/any/path?x=1", expectedResult: true},
+		{redirectURL: "https://APP.example.com/", expectedResult: true},
+		{redirectURL: "http://app.example.com/", expectedResult: false},
+		{redirectURL: "https://app.example.com:8443/", expectedResult: false},
+		{redirectURL: "https://evil.com/?https://app.example.com", expectedResult: false},
+		{redirectURL: "https://app.example.com@evil.com/", expectedResult: false},
+		{redirectURL: "https://app.example.com.evil.com/", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso", expectedResult: true},
+		{redirectURL: "https://a.b.example.org/sso/done", expectedResult: true},
+		{redirectURL: "https://example.org/sso", expectedResult: false},
+		{redirectURL: "https://team.example.org/ssofake", expectedResult: false},
+		{redirectURL: "https://team.example.org/", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/../admin", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/%2e%2e/admin", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/%2E%2E/admin", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/..%2fadmin", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/..", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/./done", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso//done", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/..\\admin", expectedResult: false},
+		{redirectURL: "https://app.example.com/a/../b", expectedResult: false},
+		{redirectURL: "https://team.example.org/sso/done/", expectedResult: true},
+		{redirectURL: "https://team.example.org/sso/..done", expectedResult: true},
+		{redirectURL: "http://localhost:3000/callback", expectedResult: true},
+		{redirectURL: "http://localhost/callback", expectedResult: false},
+		{redirectURL: "/relative/path", expectedResult: false},
+		{redirectURL: "//app.example.com/", expectedResult: false},
+	}
+
+	for _, test := range tests {
+		redirectURL, err := url.Parse(test.redirectURL)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expectedResult, isAllowedRedirect(&config.Config, redirectURL), test.redirectURL)
+	}
+}
+
+func TestIsAllowedOrigin(t *testing.T) {
+	config.Config.AllowedOrigins = []string{"https://app.example.com", "https://*.example.org"}
+	defer func() { config.Config.AllowedOrigins = nil }()
+
+	tests := []struct {
+		origin         string
+		expectedResult bool
+	}{
+		{origin: "https://app.example.com", expectedResult: true},
+		{origin: "https://team.example.org", expectedResult: true},
+		{origin: "http://app.example.com", expectedResult: false},
+		{origin: "https://app.example.com/path", expectedResult: false},
+		{origin: "null", expectedResult: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedResult, isAllowedOrigin(&config.Config, test.origin), test.origin)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/assertion"
+	"github.com/vedhavyas/oauth2_central/providers"
+	"github.com/vedhavyas/oauth2_central/utilities"
+)
+
+// assertionLifetime is how long a service has to verify the identity assertion
+const assertionLifetime = 2 * time.Minute
+
+// newIdentityAssertion signs the identity with the secret of the service at redirectURL, bound to the state it sent
+func newIdentityAssertion(r *http.Request, redirectURL *url.URL,
+	authResponse *providers.AuthResponse, sourceState string) (string, error) {
+	id, err := utilities.GenerateRandomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &assertion.Claims{
+		ID:            id,
+		Issuer:        getOrigin(r),
+		Subject:       authResponse.Email,
+		Audience:      redirectURL.Scheme + "://" + redirectURL.Host,
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(assertionLifetime).Unix(),
+		State:         sourceState,
+		Provider:      authResponse.Provider,
+		Name:          authResponse.Name,
+		Email:         authResponse.Email,
+		EmailVerified: authResponse.EmailVerified,
+	}
+
+	secret := assertion.AudienceSecret([]byte(requestSnapshot(r).Config.AssertionSecret), claims.Audience)
+	return assertion.Sign(claims, secret)
+}
+
+// getOrigin returns scheme://host oauth2_central is serving the request on
+func getOrigin(r *http.Request) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host
+}
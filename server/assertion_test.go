@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/assertion"
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/providers"
+)
+
+func TestNewIdentityAssertion(t *testing.T) {
+	assertionSecret := "a very long secret used to sign the test assertions"
+	r := withConfig(httptest.NewRequest("GET", "/oauth2/callback", nil), config.Configuration{AssertionSecret: assertionSecret})
+	redirectURL, _ := url.Parse("https://app.example.com/home")
+
+	token, err := newIdentityAssertion(r, redirectURL, &providers.AuthResponse{Email: "john@example.com", Provider: "google"}, "app_state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the service verifies it with the secret of its own audience, not the assertion_secret
+	_, err = assertion.Verify(token, []byte(assertionSecret), "https://app.example.com", "app_state", assertion.NewMemoryReplayCache())
+	assert.Equal(t, assertion.ErrInvalidSignature, err)
+
+	secret := assertion.AudienceSecret([]byte(assertionSecret), "https://app.example.com")
+	claims, err := assertion.Verify(token, secret, "https://app.example.com", "app_state", assertion.NewMemoryReplayCache())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "john@example.com", claims.Email)
+		assert.Equal(t, "https://app.example.com", claims.Audience)
+	}
+}
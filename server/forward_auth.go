@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vedhavyas/oauth2_central/providers"
+)
+
+// identity headers set on successful authentication for the gateways and upstreams
+const (
+	headerEmail    = "X-Auth-Request-Email"
+	headerUser     = "X-Auth-Request-User"
+	headerName     = "X-Auth-Request-Name"
+	headerGroups   = "X-Auth-Request-Groups"
+	headerRoles    = "X-Auth-Request-Roles"
+	headerProvider = "X-Auth-Request-Provider"
+)
+
+var identityHeaders = []string{headerEmail, headerUser, headerName, headerGroups, headerRoles, headerProvider}
+
+// setIdentityHeaders sets the identity of the authenticated user on the headers
+func setIdentityHeaders(header http.Header, authResponse *providers.AuthResponse) {
+	user := authResponse.Login
+	if user == "" {
+		user = authResponse.Email
+	}
+
+	header.Set(headerEmail, authResponse.Email)
+	header.Set(headerUser, user)
+	header.Set(headerName, authResponse.Name)
+	header.Set(headerProvider, authResponse.Provider)
+	if len(authResponse.Groups) > 0 {
+		header.Set(headerGroups, strings.Join(authResponse.Groups, ","))
+	}
+	if len(authResponse.Roles) > 0 {
+		header.Set(headerRoles, strings.Join(authResponse.Roles, ","))
+	}
+}
+
+// isBrowserRequest determines whether the original request came from a browser navigation
+func isBrowserRequest(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// getForwardedURL rebuilds the url the gateway received from the X-Forwarded-* headers.
+// Traefik sends the path in X-Forwarded-Uri and nginx is usually configured to send X-Original-URI
+func getForwardedURL(r *http.Request) (*url.URL, bool) {
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	host := r.Header.Get("X-Forwarded-Host")
+	if scheme == "" || host == "" {
+		return nil, false
+	}
+
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.Header.Get("X-Original-URI")
+	}
+	if uri == "" {
+		uri = "/"
+	}
+
+	forwardedURL, err := url.Parse(scheme + "://" + host + uri)
+	if err != nil {
+		return nil, false
+	}
+	return forwardedURL, true
+}
+
+// getStartURL returns the /oauth2/start url which brings the user back to redirectURL after login
+func getStartURL(r *http.Request, redirectURL *url.URL, providerName string) string {
+	baseURL := requestSnapshot(r).Config.ExternalURL
+	if baseURL == "" {
+		baseURL = getOrigin(r)
+	}
+
+	params := url.Values{}
+	params.Set("redirect_url", redirectURL.String())
+	if providerName != "" {
+		params.Set("provider", providerName)
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/oauth2/start?" + params.Encode()
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/providers"
+)
+
+func TestGetForwardedURL(t *testing.T) {
+	tests := []struct {
+		headers        map[string]string
+		expectedResult string
+	}{
+		{headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "app.example.com", "X-Forwarded-Uri": "/path?x=1"},
+			expectedResult: "https://app.example.com/path?x=1"},
+		{headers: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "app.example.com", "X-Original-URI": "/nginx"},
+			expectedResult: "https://app.example.com/nginx"},
+		{headers: map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "app.example.com"},
+			expectedResult: "http://app.example.com/"},
+		{headers: map[string]string{"X-Forwarded-Host": "app.example.com"}, expectedResult: ""},
+	}
+
+	for _, test := range tests {
+		r := &http.Request{Header: http.Header{}, URL: &url.URL{}}
+		for key, value := range test.headers {
+			r.Header.Set(key, value)
+		}
+
+		forwardedURL, ok := getForwardedURL(r)
+		if test.expectedResult == "" {
+			assert.False(t, ok)
+			continue
+		}
+		assert.Equal(t, test.expectedResult, forwardedURL.String())
+	}
+}
+
+func TestGetStartURL(t *testing.T) {
+	redirectURL, _ := url.Parse("https://app.example.com/path")
+	r := &http.Request{Host: "oauth2:8080", URL: &url.URL{}}
+
+	assert.Equal(t, "http://oauth2:8080/oauth2/start?redirect_url=https%3A%2F%2Fapp.example.com%2Fpath",
+		getStartURL(withConfig(r, config.Configuration{}), redirectURL, ""))
+
+	r = withConfig(r, config.Configuration{ExternalURL: "https://sso.example.com/"})
+	assert.Equal(t, "https://sso.example.com/oauth2/start?provider=github&redirect_url=https%3A%2F%2Fapp.example.com%2Fpath",
+		getStartURL(r, redirectURL, "github"))
+}
+
+func TestSetIdentityHeaders(t *testing.T) {
+	header := http.Header{}
+	setIdentityHeaders(header, &providers.AuthResponse{
+		Email:    "john@example.com",
+		Name:     "John",
+		Login:    "john",
+		Provider: "github",
+		Groups:   []string{"org/admins", "org/devs"},
+		Roles:    []string{"Reader"},
+	})
+
+	assert.Equal(t, "john@example.com", header.Get(headerEmail))
+	assert.Equal(t, "john", header.Get(headerUser))
+	assert.Equal(t, "org/admins,org/devs", header.Get(headerGroups))
+	assert.Equal(t, "Reader", header.Get(headerRoles))
+	assert.Equal(t, "github", header.Get(headerProvider))
+}
@@ -1,14 +1,18 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"log"
 
+	gorillaSessions "github.com/gorilla/sessions"
 	"github.com/vedhavyas/oauth2_central/config"
 	"github.com/vedhavyas/oauth2_central/helpers"
 	"github.com/vedhavyas/oauth2_central/providers"
@@ -23,6 +27,7 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 
 //StartAuthHandler callback to handle all oauth start requests
 func StartAuthHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := requestSnapshot(r)
 	authRes, authError := isAuthenticated(w, r)
 
 	providerName := r.Form.Get("provider")
@@ -30,7 +35,7 @@ func StartAuthHandler(w http.ResponseWriter, r *http.Request) {
 		providerName = "google"
 	}
 
-	provider := providers.GetProvider(providerName)
+	provider := providers.GetProvider(snapshot.Config, providerName)
 	rawRedirectURL := r.Form.Get("redirect_url")
 	sourceState := r.Form.Get("state")
 
@@ -47,6 +52,12 @@ func StartAuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isAllowedRedirect(snapshot.Config, redirectURL) {
+		log.Printf("redirect_url %s is not allowed\n", rawRedirectURL)
+		http.Error(w, "redirect_url is not allowed", http.StatusBadRequest)
+		return
+	}
+
 	if authError == nil {
 		log.Printf("Successfully Authenticated user %s \n", authRes.Email)
 		redirectSuccessAuth(w, r, redirectURL, authRes, sourceState)
@@ -64,19 +75,81 @@ func StartAuthHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-//AuthenticateHandler handles all authenticate requests
+//AuthenticateHandler handles all authenticate requests.
+//Sets the identity headers on success so that it can be used by nginx auth_request and Traefik ForwardAuth.
+//Browser requests are redirected to the login if authenticate_redirect is enabled
 func AuthenticateHandler(w http.ResponseWriter, r *http.Request) {
 	authRes, err := isAuthenticated(w, r)
 	if err != nil {
 		log.Println("authentication failed")
+		if requestSnapshot(r).Config.AuthenticateRedirect && isBrowserRequest(r) {
+			redirectToStart(w, r)
+			return
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
 	log.Printf("Successfully Authenticated user %s \n", authRes.Email)
+	setIdentityHeaders(w.Header(), authRes)
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// redirectToStart redirects the browser to the login with the original url as the redirect_url
+func redirectToStart(w http.ResponseWriter, r *http.Request) {
+	forwardedURL, ok := getForwardedURL(r)
+	if !ok || !isAllowedRedirect(requestSnapshot(r).Config, forwardedURL) {
+		log.Println("missing or not allowed forwarded url")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(w, r, getStartURL(r, forwardedURL, r.Form.Get("provider")), http.StatusFound)
+}
+
+//UserInfoHandler returns the profile of the authenticated user as JSON
+func UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, r)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	authRes, err := isAuthenticated(w, r)
+	if err != nil {
+		log.Println(err)
+		if _, ok := err.(*helpers.UnRecoverableError); ok {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(authRes); err != nil {
+		log.Println(err)
+	}
+}
+
+// setCORSHeaders allows credentialed cross origin requests from the allowed_origins
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" || !isAllowedOrigin(requestSnapshot(r).Config, origin) {
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET")
+		w.Header().Set("Access-Control-Max-Age", "600")
+	}
+}
+
 func isAuthenticated(w http.ResponseWriter, r *http.Request) (*providers.AuthResponse, error) {
 	err := r.ParseForm()
 	if err != nil {
@@ -88,9 +161,15 @@ func isAuthenticated(w http.ResponseWriter, r *http.Request) (*providers.AuthRes
 		providerName = "google"
 	}
 
-	provider := providers.GetProvider(providerName)
+	return isAuthenticatedWith(w, r, providerName)
+}
+
+// isAuthenticatedWith checks the session for the given provider without parsing the request form
+func isAuthenticatedWith(w http.ResponseWriter, r *http.Request, providerName string) (*providers.AuthResponse, error) {
+	snapshot := requestSnapshot(r)
+	provider := providers.GetProvider(snapshot.Config, providerName)
 
-	session, err := sessions.DefaultCookieStore.Get(r, fmt.Sprintf("%s_oauth", config.Config.CookieNameSpace))
+	session, err := snapshot.DefaultStore.Get(r, sessionCookieName(snapshot.Config))
 	if err != nil {
 		log.Println(err)
 		return nil, helpers.NewUnRecoverableError(err.Error())
@@ -102,8 +181,27 @@ func isAuthenticated(w http.ResponseWriter, r *http.Request) (*providers.AuthRes
 		return nil, helpers.NewRecoverableError("Access token missing")
 	}
 
-	authResponse, err := provider.GetProfileDataFromAccessToken(accessToken.(string))
-	if err == nil {
+	// expired sessions need a fresh login rather than a refresh
+	expired, touched := checkSessionTimeouts(snapshot, session.Values, providerName, time.Now())
+	if expired {
+		log.Println("session expired")
+		expireSession(session.Values, providerName)
+		err = session.Save(r, w)
+		if err != nil {
+			log.Println(err)
+			return nil, helpers.NewUnRecoverableError(err.Error())
+		}
+		return nil, helpers.NewRecoverableError("Session expired")
+	}
+
+	// the providers returning the profile with the tokens have it kept in the session until the tokens expire
+	authResponse, ok := getSessionProfile(session.Values, providerName, time.Now())
+	if !ok {
+		authResponse, err = provider.GetProfileDataFromAccessToken(accessToken.(string))
+	}
+	if ok || err == nil {
+		saveTouchedSession(w, r, session, touched)
+		authResponse.Provider = providerName
 		return authResponse, nil
 	}
 
@@ -119,23 +217,56 @@ func isAuthenticated(w http.ResponseWriter, r *http.Request) (*providers.AuthRes
 		return nil, helpers.NewRecoverableError(err.Error())
 	}
 
-	authResponse, err = provider.GetProfileDataFromAccessToken(redeemResponse.AccessToken)
-	if err != nil {
-		log.Println("Failed to fetch profile info after authentication")
-		return nil, helpers.NewUnRecoverableError(err.Error())
+	authResponse = redeemResponse.Profile
+	if authResponse == nil {
+		authResponse, err = provider.GetProfileDataFromAccessToken(redeemResponse.AccessToken)
+		if err != nil {
+			log.Println("Failed to fetch profile info after authentication")
+			return nil, helpers.NewUnRecoverableError(err.Error())
+		}
 	}
 
 	session.Values[fmt.Sprintf("%s_access_token", providerName)] = redeemResponse.AccessToken
 	session.Values[fmt.Sprintf("%s_refresh_token", providerName)] = redeemResponse.RefreshToken
+	err = setSessionProfile(session.Values, providerName, redeemResponse)
+	if err != nil {
+		log.Println(err)
+		return nil, helpers.NewUnRecoverableError(err.Error())
+	}
 	err = session.Save(r, w)
 	if err != nil {
 		log.Println(err)
 		return nil, helpers.NewUnRecoverableError(err.Error())
 	}
 
+	authResponse.Provider = providerName
 	return authResponse, nil
 }
 
+// sessionCookieName returns the name of the cookie holding the long lived session
+func sessionCookieName(c *config.Configuration) string {
+	return fmt.Sprintf("%s%s_oauth", c.CookiePrefix, c.CookieNameSpace)
+}
+
+// stateCookieName returns the name of the cookie holding the state of a login with the provider.
+// Each login gets its own cookie, keyed by the state token, so that parallel logins don't overwrite each other.
+// The token's base64 padding is trimmed since cookie names can't carry '='
+func stateCookieName(c *config.Configuration, providerName, token string) string {
+	return fmt.Sprintf("%s%s_state_%s", c.CookiePrefix, providerName, strings.TrimRight(token, "="))
+}
+
+// saveTouchedSession saves the session when its values changed or when its cookie was encoded with a rotated key
+func saveTouchedSession(w http.ResponseWriter, r *http.Request, session *gorillaSessions.Session, touched bool) {
+	if !touched && !sessions.IsStale(r, requestSnapshot(r).DefaultStore, session.Name()) {
+		return
+	}
+
+	err := session.Save(r, w)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func fetchNewTokens(w http.ResponseWriter, r *http.Request,
 	provider providers.Provider, rawRedirectURL string, sourceState string) {
 	randomToken, err := utilities.GenerateRandomString(32)
@@ -145,14 +276,23 @@ func fetchNewTokens(w http.ResponseWriter, r *http.Request,
 	}
 
 	//create a new session for state management
-	currentSession, err := sessions.ShortLiveCookie.Get(r, fmt.Sprintf("%s_save_state", provider.Data().ProviderName))
+	snapshot := requestSnapshot(r)
+	currentSession, err := snapshot.ShortLiveStore.Get(r, stateCookieName(snapshot.Config, provider.Data().ProviderName, randomToken))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	state := fmt.Sprintf("%s||%s", provider.Data().ProviderName, randomToken)
+	authState, err := providers.NewAuthState(state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	currentSession.Values["state"] = randomToken
+	currentSession.Values["code_verifier"] = authState.CodeVerifier
+	currentSession.Values["nonce"] = authState.Nonce
 	currentSession.Values["redirect_url"] = rawRedirectURL
 	currentSession.Values["source_state"] = sourceState
 	err = currentSession.Save(r, w)
@@ -161,7 +301,7 @@ func fetchNewTokens(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	provider.RedirectToAuthPage(w, r, state)
+	provider.RedirectToAuthPage(w, r, authState)
 }
 
 //CallbackHandler handles all Auth callbacks
@@ -171,62 +311,35 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	receivedState := r.Form.Get("state")
-	if receivedState == "" {
-		log.Println("recieved no state from provider")
-		http.Error(w, "recieved no state from provider", http.StatusInternalServerError)
-		return
-	}
-
-	dataParts := strings.Split(receivedState, "||")
-	if len(dataParts) < 2 {
-		log.Println("received malformed state")
-		http.Error(w, "received malformed state", http.StatusInternalServerError)
-		return
-	}
-	providerName := dataParts[0]
-	receivedToken := dataParts[1]
-
-	provider := providers.GetProvider(providerName)
-
-	currentSession, err := sessions.ShortLiveCookie.Get(r, fmt.Sprintf("%s_save_state", providerName))
+	state, err := loadLoginState(r, r.Form.Get("state"))
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	expectedState, ok := currentSession.Values["state"]
-	if !ok {
-		log.Println("short lived cookie is missing")
-		http.Error(w, "short lived cookie is missing", http.StatusInternalServerError)
-		return
-	}
-
-	if expectedState != receivedToken {
-		log.Println("state mismatch")
-		http.Error(w, "state mismatch", http.StatusInternalServerError)
-		return
-	}
-
-	rawRedirectURL := currentSession.Values["redirect_url"].(string)
-	sourceState := currentSession.Values["source_state"].(string)
-
-	currentSession.Options.MaxAge = -1
-
-	if err = currentSession.Save(r, w); err != nil {
+	snapshot := requestSnapshot(r)
+	provider := providers.GetProvider(snapshot.Config, state.providerName)
+	if err = consumeLoginState(w, r, state); err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	redirectURL, err := url.Parse(rawRedirectURL)
+	redirectURL, err := url.Parse(state.rawRedirectURL)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if !isAllowedRedirect(snapshot.Config, redirectURL) {
+		log.Printf("redirect_url %s is not allowed\n", state.rawRedirectURL)
+		http.Error(w, "redirect_url is not allowed", http.StatusBadRequest)
+		return
+	}
+
+	sourceState := state.sourceState
 	if errorMessage := r.Form.Get("error"); errorMessage != "" {
 		log.Println(errorMessage)
 		redirectFailedAuth(w, r, redirectURL, sourceState, errorMessage)
@@ -240,7 +353,8 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	redeemResponse, err := provider.RedeemCode(code, providers.GetAuthCallBackURL(r), receivedState)
+	authState := state.authState
+	redeemResponse, err := provider.RedeemCode(code, providers.GetAuthCallBackURL(r), authState)
 	if err != nil {
 		log.Println(err)
 		redirectFailedAuth(w, r, redirectURL, sourceState, err.Error())
@@ -249,9 +363,13 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	var authRes = &providers.AuthResponse{}
 	if redeemResponse.IDToken != "" {
-		if err := providers.GetProfileFromIDToken(authRes, redeemResponse.IDToken); err != nil {
+		if err := providers.GetProfileFromIDToken(provider, authRes, redeemResponse.IDToken, authState.Nonce); err != nil {
 			log.Println(err)
-			redirectFailedAuth(w, r, redirectURL, sourceState, err.Error())
+			errorMessage := err.Error()
+			if tokenErr, ok := err.(*providers.IDTokenError); ok {
+				errorMessage = tokenErr.Code
+			}
+			redirectFailedAuth(w, r, redirectURL, sourceState, errorMessage)
 			return
 		}
 	}
@@ -265,7 +383,68 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	session, err := sessions.DefaultCookieStore.Get(r, fmt.Sprintf("%s_oauth", config.Config.CookieNameSpace))
+	finishLogin(w, r, state.providerName, redeemResponse, authRes, redirectURL, sourceState)
+}
+
+// loginState is the state of a login in progress, kept in its own state cookie
+type loginState struct {
+	providerName   string
+	session        *gorillaSessions.Session
+	authState      *providers.AuthState
+	rawRedirectURL string
+	sourceState    string
+}
+
+// loadLoginState loads the state cookie of the received state, provider||token, and checks it belongs to the login
+func loadLoginState(r *http.Request, receivedState string) (*loginState, error) {
+	if receivedState == "" {
+		return nil, errors.New("recieved no state from provider")
+	}
+
+	dataParts := strings.Split(receivedState, "||")
+	if len(dataParts) < 2 {
+		return nil, errors.New("received malformed state")
+	}
+	providerName := dataParts[0]
+	receivedToken := dataParts[1]
+
+	snapshot := requestSnapshot(r)
+	currentSession, err := snapshot.ShortLiveStore.Get(r, stateCookieName(snapshot.Config, providerName, receivedToken))
+	if err != nil {
+		return nil, err
+	}
+
+	expectedState, ok := currentSession.Values["state"]
+	if !ok {
+		return nil, errors.New("short lived cookie is missing")
+	}
+
+	if expectedState != receivedToken {
+		return nil, errors.New("state mismatch")
+	}
+
+	state := &loginState{providerName: providerName, session: currentSession}
+	state.rawRedirectURL, _ = currentSession.Values["redirect_url"].(string)
+	state.sourceState, _ = currentSession.Values["source_state"].(string)
+	state.authState = &providers.AuthState{State: receivedState}
+	state.authState.CodeVerifier, _ = currentSession.Values["code_verifier"].(string)
+	state.authState.Nonce, _ = currentSession.Values["nonce"].(string)
+	return state, nil
+}
+
+// consumeLoginState removes the state cookie so that the state can't be used again
+func consumeLoginState(w http.ResponseWriter, r *http.Request, state *loginState) error {
+	state.session.Options.MaxAge = -1
+	return state.session.Save(r, w)
+}
+
+// finishLogin keeps the tokens of the provider in the session and redirects back with the identity
+func finishLogin(w http.ResponseWriter, r *http.Request, providerName string, redeemResponse *providers.RedeemResponse,
+	authRes *providers.AuthResponse, redirectURL *url.URL, sourceState string) {
+	authRes.Provider = providerName
+
+	snapshot := requestSnapshot(r)
+	session, err := snapshot.DefaultStore.Get(r, sessionCookieName(snapshot.Config))
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -274,6 +453,12 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	session.Values[fmt.Sprintf("%s_access_token", providerName)] = redeemResponse.AccessToken
 	session.Values[fmt.Sprintf("%s_refresh_token", providerName)] = redeemResponse.RefreshToken
+	stampSession(session.Values, providerName, time.Now())
+	if err := setSessionProfile(session.Values, providerName, redeemResponse); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	if err := session.Save(r, w); err != nil {
 		log.Println(err)
@@ -287,23 +472,141 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 func redirectSuccessAuth(w http.ResponseWriter, r *http.Request,
 	redirectURL *url.URL, authResponse *providers.AuthResponse, sourceState string) {
-	params := url.Values{}
-	params.Set("email", authResponse.Email)
-	params.Set("email_verified", strconv.FormatBool(authResponse.EmailVerified))
-	params.Set("name", authResponse.Name)
+	c := requestSnapshot(r).Config
+	params := redirectURL.Query()
+	if c.AssertionSecret != "" {
+		signedAssertion, err := newIdentityAssertion(r, redirectURL, authResponse, sourceState)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		params.Set("assertion", signedAssertion)
+	}
+
+	if c.LegacyRedirectParams {
+		params.Set("email", authResponse.Email)
+		params.Set("email_verified", strconv.FormatBool(authResponse.EmailVerified))
+		params.Set("name", authResponse.Name)
+	}
 	params.Set("state", sourceState)
 	redirectURL.RawQuery = params.Encode()
 	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
 }
 
 func redirectFailedAuth(w http.ResponseWriter, r *http.Request, redirectURL *url.URL, sourceState string, errorMessage string) {
-	params := url.Values{}
+	params := redirectURL.Query()
 	params.Set("error", errorMessage)
 	params.Set("state", sourceState)
 	redirectURL.RawQuery = params.Encode()
 	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
 }
 
+//LogoutHandler clears the session of the given provider, or of all the providers if none is given,
+//revokes their tokens and redirects to the redirect_url if one is provided.
+//Only POSTs from oauth central itself or the allowed_origins are accepted so that other sites can't log the users out.
+//The other methods are answered here rather than falling through to the upstreams
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := requestSnapshot(r)
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isAllowedFormOrigin(r) {
+		log.Printf("logout posted from origin %q referer %q is not allowed\n", r.Header.Get("Origin"), r.Referer())
+		http.Error(w, "origin is not allowed", http.StatusForbidden)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var redirectURL *url.URL
+	if rawRedirectURL := r.Form.Get("redirect_url"); rawRedirectURL != "" {
+		var err error
+		redirectURL, err = url.Parse(rawRedirectURL)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !isAllowedRedirect(snapshot.Config, redirectURL) {
+			log.Printf("redirect_url %s is not allowed\n", rawRedirectURL)
+			http.Error(w, "redirect_url is not allowed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, err := snapshot.DefaultStore.Get(r, sessionCookieName(snapshot.Config))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	providerNames := []string{r.Form.Get("provider")}
+	if providerNames[0] == "" {
+		providerNames = getSessionProviders(session.Values)
+		session.Options.MaxAge = -1
+	}
+
+	for _, providerName := range providerNames {
+		revokeTokens(providers.GetProvider(snapshot.Config, providerName), providerName, session.Values)
+		expireSession(session.Values, providerName)
+	}
+
+	if err = session.Save(r, w); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("logged out of %s\n", strings.Join(providerNames, ", "))
+	if redirectURL == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// getSessionProviders returns the names of the providers holding tokens in the session
+func getSessionProviders(values map[interface{}]interface{}) []string {
+	var providerNames []string
+	for key := range values {
+		name, ok := key.(string)
+		if ok && strings.HasSuffix(name, "_access_token") {
+			providerNames = append(providerNames, strings.TrimSuffix(name, "_access_token"))
+		}
+	}
+	return providerNames
+}
+
+// revokeTokens revokes the provider tokens held in the session. Failures are only logged
+// since the session is cleared regardless
+func revokeTokens(provider providers.Provider, providerName string, values map[interface{}]interface{}) {
+	revoker, ok := provider.(providers.Revoker)
+	if !ok {
+		return
+	}
+
+	for _, key := range []string{"refresh_token", "access_token"} {
+		token, ok := values[fmt.Sprintf("%s_%s", providerName, key)].(string)
+		if !ok || token == "" {
+			continue
+		}
+
+		if err := revoker.RevokeToken(token); err != nil {
+			log.Printf("failed to revoke %s %s: %v\n", providerName, key, err)
+		}
+	}
+}
+
 //PingHandler handles the ping
 func PingHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/sessions"
+)
+
+// startLogin starts a login with the test provider and returns the state sent to the provider and the state cookie
+func startLogin(t *testing.T, redirectURL string) (string, *http.Cookie) {
+	query := url.Values{"provider": {"test"}, "redirect_url": {redirectURL}}
+	r := httptest.NewRequest("GET", "/oauth2/start?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	StartAuthHandler(w, r)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a state cookie, got %v", cookies)
+	}
+
+	return location.Query().Get("state"), cookies[0]
+}
+
+func TestCallbackHandler_ParallelLogins(t *testing.T) {
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+	config.Config.CookieNameSpace = "test"
+	config.Config.CookieSecret = "a very long secret used to sign the test cookies"
+	config.Config.AllowedRedirects = []string{"https://app.example.com"}
+	config.Config.Providers = []config.ProviderConfig{{Name: "test", Type: "oidc", IssuerURL: issuer.URL}}
+	if err := sessions.InitiateStores(); err != nil {
+		t.Fatal(err)
+	}
+
+	firstState, firstCookie := startLogin(t, "https://app.example.com/first")
+	secondState, secondCookie := startLogin(t, "https://app.example.com/second")
+	assert.NotEqual(t, firstCookie.Name, secondCookie.Name)
+
+	// the logins finish independently, each with its own redirect_url
+	for _, login := range []struct {
+		state       string
+		cookie      *http.Cookie
+		redirectURL string
+	}{
+		{state: secondState, cookie: secondCookie, redirectURL: "https://app.example.com/second"},
+		{state: firstState, cookie: firstCookie, redirectURL: "https://app.example.com/first"},
+	} {
+		query := url.Values{"state": {login.state}, "error": {"access_denied"}}
+		r := httptest.NewRequest("GET", "/oauth2/callback?"+query.Encode(), nil)
+		r.AddCookie(login.cookie)
+		w := httptest.NewRecorder()
+		CallbackHandler(w, r)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		location, _ := url.Parse(w.Header().Get("Location"))
+		assert.Equal(t, login.redirectURL, location.Scheme+"://"+location.Host+location.Path)
+
+		// the state is single use
+		cookies := w.Result().Cookies()
+		assert.Equal(t, login.cookie.Name, cookies[0].Name)
+		assert.True(t, cookies[0].MaxAge < 0)
+	}
+
+	// a state without its cookie is rejected
+	query := url.Values{"state": {firstState}, "error": {"access_denied"}}
+	r := httptest.NewRequest("GET", "/oauth2/callback?"+query.Encode(), nil)
+	r.AddCookie(secondCookie)
+	w := httptest.NewRecorder()
+	CallbackHandler(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestLogoutHandler(t *testing.T) {
+	savedConfig := config.Config
+	defer func() {
+		config.Config = savedConfig
+		sessions.InitiateStores()
+	}()
+	config.Config.CookieNameSpace = "test"
+	config.Config.CookieSecret = "a very long secret used to sign the test cookies"
+	config.Config.AllowedOrigins = []string{"https://app.example.com"}
+	if err := sessions.InitiateStores(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method         string
+		origin         string
+		referer        string
+		expectedStatus int
+	}{
+		{method: "GET", expectedStatus: http.StatusMethodNotAllowed},
+		{method: "POST", origin: "https://evil.com", expectedStatus: http.StatusForbidden},
+		{method: "POST", origin: "null", expectedStatus: http.StatusForbidden},
+		{method: "POST", expectedStatus: http.StatusForbidden},
+		{method: "POST", origin: "http://central.example.com", expectedStatus: http.StatusOK},
+		{method: "POST", origin: "https://app.example.com", expectedStatus: http.StatusOK},
+
+		// the browsers not sending an Origin are checked by the Referer
+		{method: "POST", referer: "http://central.example.com/oauth2/login", expectedStatus: http.StatusOK},
+		{method: "POST", referer: "https://app.example.com/account", expectedStatus: http.StatusOK},
+		{method: "POST", referer: "https://evil.com/central.example.com", expectedStatus: http.StatusForbidden},
+		{method: "POST", referer: "/relative", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "http://central.example.com/oauth2/logout?provider=test", nil)
+		r.AddCookie(newSessionCookie(t, "test", "valid_token"))
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.referer != "" {
+			r.Header.Set("Referer", test.referer)
+		}
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, r)
+		assert.Equal(t, test.expectedStatus, w.Code, test.method+" "+test.origin+test.referer)
+
+		// only the accepted logouts clear the session
+		cleared := false
+		for _, c := range w.Result().Cookies() {
+			cleared = cleared || c.Name == sessionCookieName(&config.Config)
+		}
+		assert.Equal(t, test.expectedStatus == http.StatusOK, cleared, test.method+" "+test.origin+test.referer)
+	}
+
+	// the logout isn't proxied to an upstream of the root path
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s %s reached the upstream", r.Method, r.URL.Path)
+	}))
+	defer upstream.Close()
+	config.Config.Upstreams = []config.UpstreamConfig{{Path: "/", URL: upstream.URL, Provider: "test"}}
+	if err := sessions.InitiateStores(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{"GET", "HEAD", "PUT"} {
+		r := httptest.NewRequest(method, "http://central.example.com/oauth2/logout", nil)
+		r.AddCookie(newSessionCookie(t, "test", "valid_token"))
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, method)
+		assert.Equal(t, "POST", w.Header().Get("Allow"), method)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/providers"
+)
+
+const (
+	// maxAddressFailures is how many failed logins a client address may make within the addressFailureWindow
+	maxAddressFailures = 20
+
+	// addressFailureWindow is how long the failed logins of a client address are counted
+	addressFailureWindow = 15 * time.Minute
+)
+
+// addressFailures counts the failed logins by client address across the usernames and the password providers,
+// so that a client guessing the passwords of many users is throttled along with the usernames backing off
+var addressFailures = struct {
+	sync.Mutex
+	entries map[string]*addressWindow
+}{entries: make(map[string]*addressWindow)}
+
+// addressWindow counts the failed logins of a client address since the start of the window
+type addressWindow struct {
+	failures int
+	start    time.Time
+}
+
+// loginForm is the login form of the password providers
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+</head>
+<body>
+<form method="POST" action="/oauth2/login">
+<h1>Sign in</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<input type="hidden" name="state" value="{{.State}}">
+<p><label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username" autofocus required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// loginFormData fills the login form
+type loginFormData struct {
+	State    string
+	Username string
+	Error    string
+}
+
+//LoginHandler shows the login form of the password providers and authenticates the username and password posted to it.
+//The login is started with /oauth2/start like any other, so successful logins get the same session and redirect as the callback
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state, err := loadLoginState(r, r.Form.Get("state"))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snapshot := requestSnapshot(r)
+	provider := providers.GetProvider(snapshot.Config, state.providerName)
+	passwordProvider, ok := provider.(providers.PasswordProvider)
+	if !ok {
+		log.Printf("provider %s doesn't login with a password\n", state.providerName)
+		http.Error(w, "provider doesn't login with a password", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	formData := loginFormData{State: state.authState.State}
+	if r.Method == "GET" {
+		renderLoginForm(w, http.StatusOK, formData)
+		return
+	}
+
+	// the state stays valid after a wrong password so that the user can try again
+	formData.Username = r.PostForm.Get("username")
+	address := clientAddress(snapshot.Config, r)
+	if isThrottled(address, time.Now()) {
+		log.Printf("too many failed logins from %s\n", address)
+		formData.Error = "Too many failed logins, try again later"
+		renderLoginForm(w, http.StatusTooManyRequests, formData)
+		return
+	}
+
+	redeemResponse, authErr := passwordProvider.Authenticate(formData.Username, r.PostForm.Get("password"))
+	if authErr == providers.ErrInvalidCredentials {
+		log.Printf("invalid credentials for %s from %s\n", formData.Username, address)
+		recordAddressFailure(address, time.Now())
+		formData.Error = "Invalid username or password"
+		renderLoginForm(w, http.StatusUnauthorized, formData)
+		return
+	}
+
+	if authErr == providers.ErrAccountLocked {
+		log.Printf("%s is backing off after too many failed logins\n", formData.Username)
+		recordAddressFailure(address, time.Now())
+		formData.Error = "Too many failed logins, try again later"
+		renderLoginForm(w, http.StatusTooManyRequests, formData)
+		return
+	}
+
+	if err = consumeLoginState(w, r, state); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	redirectURL, err := url.Parse(state.rawRedirectURL)
+	if err != nil || !isAllowedRedirect(snapshot.Config, redirectURL) {
+		log.Printf("redirect_url %s is not allowed\n", state.rawRedirectURL)
+		http.Error(w, "redirect_url is not allowed", http.StatusBadRequest)
+		return
+	}
+
+	if authErr != nil {
+		log.Println(authErr)
+		redirectFailedAuth(w, r, redirectURL, state.sourceState, authErr.Error())
+		return
+	}
+
+	authRes, err := provider.GetProfileDataFromAccessToken(redeemResponse.AccessToken)
+	if err != nil {
+		log.Println(err)
+		redirectFailedAuth(w, r, redirectURL, state.sourceState, err.Error())
+		return
+	}
+
+	finishLogin(w, r, state.providerName, redeemResponse, authRes, redirectURL, state.sourceState)
+}
+
+func renderLoginForm(w http.ResponseWriter, status int, formData loginFormData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := loginForm.Execute(w, formData); err != nil {
+		log.Println(err)
+	}
+}
+
+// clientAddress returns the IP address the request came from. Behind the trusted_proxies it is the address they forwarded,
+// the right most one of X-Forwarded-For which isn't a trusted proxy, as the clients can send any addresses before it
+func clientAddress(c *config.Configuration, r *http.Request) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+
+	if !c.IsTrustedProxy(address) {
+		return address
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && c.IsTrustedProxy(address); i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		address = hop
+	}
+	return address
+}
+
+// isThrottled reports whether the client address made the maxAddressFailures within the current window
+func isThrottled(address string, now time.Time) bool {
+	addressFailures.Lock()
+	defer addressFailures.Unlock()
+	window, ok := addressFailures.entries[address]
+	return ok && now.Sub(window.start) < addressFailureWindow && window.failures >= maxAddressFailures
+}
+
+// recordAddressFailure counts the failed login of the client address, starting a new window once the current one is over
+func recordAddressFailure(address string, now time.Time) {
+	addressFailures.Lock()
+	defer addressFailures.Unlock()
+	for key, window := range addressFailures.entries {
+		if now.Sub(window.start) >= addressFailureWindow {
+			delete(addressFailures.entries, key)
+		}
+	}
+
+	window, ok := addressFailures.entries[address]
+	if !ok {
+		window = &addressWindow{start: now}
+		addressFailures.entries[address] = window
+	}
+	window.failures++
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/providers/ldaptest"
+	"github.com/vedhavyas/oauth2_central/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// startPasswordLogin starts the login with the password provider, which lands on the login form,
+// and returns the state of the login along with the state cookie
+func startPasswordLogin(t *testing.T, providerName string) (string, *http.Cookie) {
+	query := url.Values{"provider": {providerName}, "redirect_url": {"https://app.example.com/home"}, "state": {"app_state"}}
+	r := httptest.NewRequest("GET", "/oauth2/start?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	StartAuthHandler(w, r)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/oauth2/login", location.Path)
+	return location.Query().Get("state"), w.Result().Cookies()[0]
+}
+
+// postLogin posts the credentials to the login form along with the state cookie
+func postLogin(state string, stateCookie *http.Cookie, username, password string) *httptest.ResponseRecorder {
+	return postLoginFrom("192.0.2.1:1234", "", state, stateCookie, username, password)
+}
+
+// postLoginFrom posts the credentials to the login form from the remote address, forwarded for the given one if any
+func postLoginFrom(remoteAddr, forwardedFor string, state string, stateCookie *http.Cookie,
+	username, password string) *httptest.ResponseRecorder {
+	form := url.Values{"state": {state}, "username": {username}, "password": {password}}
+	r := httptest.NewRequest("POST", "/oauth2/login", strings.NewReader(form.Encode()))
+	r.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(stateCookie)
+	w := httptest.NewRecorder()
+	LoginHandler(w, r)
+	return w
+}
+
+func TestLoginHandler(t *testing.T) {
+	directory := ldaptest.NewServer(&ldaptest.Entry{
+		DN:         "uid=john,ou=people,dc=example,dc=com",
+		Password:   "john secret",
+		Attributes: map[string][]string{"uid": {"john"}, "cn": {"John Doe"}, "mail": {"john@example.com"}},
+	}, &ldaptest.Entry{DN: "cn=service,dc=example,dc=com", Password: "service secret"})
+	defer directory.Close()
+
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+	config.Config.CookieNameSpace = "test"
+	config.Config.CookieSecret = "a very long secret used to sign the test cookies"
+	config.Config.AllowedRedirects = []string{"https://app.example.com"}
+	config.Config.LegacyRedirectParams = true
+	config.Config.Providers = []config.ProviderConfig{{
+		Name:         "ldap",
+		Type:         "ldap",
+		ServerURL:    directory.URL,
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+		BindDN:       "cn=service,dc=example,dc=com",
+		BindPassword: "service secret",
+	}}
+	if err := sessions.InitiateStores(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, stateCookie := startPasswordLogin(t, "ldap")
+	r := httptest.NewRequest("GET", "/oauth2/login?state="+url.QueryEscape(state), nil)
+	r.AddCookie(stateCookie)
+	w := httptest.NewRecorder()
+	LoginHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `name="password"`)
+
+	// the form can't be used without the state cookie
+	r = httptest.NewRequest("GET", "/oauth2/login?state="+url.QueryEscape(state), nil)
+	w = httptest.NewRecorder()
+	LoginHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a wrong password shows the form again and keeps the state
+	w = postLogin(state, stateCookie, "john", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid username or password")
+	assert.Empty(t, w.Result().Cookies())
+
+	w = postLogin(state, stateCookie, "john", "john secret")
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, "app.example.com", location.Host)
+	assert.Equal(t, "john@example.com", location.Query().Get("email"))
+	assert.Equal(t, "app_state", location.Query().Get("state"))
+
+	var sessionCookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == stateCookie.Name {
+			assert.True(t, c.MaxAge < 0)
+		}
+		if c.Name == sessionCookieName(&config.Config) {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatal("session cookie missing")
+	}
+
+	// the session authenticates the following requests
+	r = httptest.NewRequest("GET", "/oauth2/userinfo?provider=ldap", nil)
+	r.AddCookie(sessionCookie)
+	w = httptest.NewRecorder()
+	UserInfoHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"login":"john"`)
+}
+
+func TestLoginHandler_Throttling(t *testing.T) {
+	usersFile, err := ioutil.TempFile("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(usersFile.Name())
+	johnHash, _ := bcrypt.GenerateFromPassword([]byte("john secret"), bcrypt.MinCost)
+	janeHash, _ := bcrypt.GenerateFromPassword([]byte("jane secret"), bcrypt.MinCost)
+	usersFile.WriteString("john:" + string(johnHash) + "\njane:" + string(janeHash) + "\n")
+	usersFile.Close()
+
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+	config.Config.CookieNameSpace = "test"
+	config.Config.CookieSecret = "a very long secret used to sign the test cookies"
+	config.Config.AllowedRedirects = []string{"https://app.example.com"}
+	config.Config.LegacyRedirectParams = true
+	config.Config.TrustedProxies = []string{"10.0.0.0/8"}
+	config.Config.Providers = []config.ProviderConfig{{
+		Name:             "local_throttling",
+		Type:             "local",
+		UsersFile:        usersFile.Name(),
+		MaxLoginAttempts: 2,
+	}}
+	if err := sessions.InitiateStores(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, stateCookie := startPasswordLogin(t, "local_throttling")
+	for i := 0; i < 2; i++ {
+		w := postLogin(state, stateCookie, "john", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	// the user backing off stays on the form, with the state, rather than being sent back failed
+	w := postLogin(state, stateCookie, "john", "john secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "Too many failed logins")
+	assert.Empty(t, w.Result().Cookies())
+
+	// the backoff is by username whatever the address
+	w = postLoginFrom("198.51.100.7:4321", "", state, stateCookie, "john", "john secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// the client address is throttled across the usernames, behind the trusted proxies too
+	for i := 0; i < maxAddressFailures; i++ {
+		w = postLoginFrom("10.0.0.1:4321", "203.0.113.9, 10.0.0.2", state, stateCookie, "john", "wrong")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	}
+	w = postLoginFrom("203.0.113.9:4321", "", state, stateCookie, "jane", "jane secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// the addresses forwarded by the others aren't trusted
+	w = postLoginFrom("198.51.100.7:4321", "203.0.113.9", state, stateCookie, "jane", "jane secret")
+	assert.Equal(t, http.StatusFound, w.Code)
+}
+
+func Test_clientAddress(t *testing.T) {
+	c := &config.Configuration{TrustedProxies: []string{"10.0.0.0/8"}}
+
+	cases := []struct {
+		remoteAddr   string
+		forwardedFor string
+		address      string
+	}{
+		{remoteAddr: "192.0.2.1:1234", address: "192.0.2.1"},
+		{remoteAddr: "192.0.2.1:1234", forwardedFor: "203.0.113.9", address: "192.0.2.1"},
+		{remoteAddr: "10.0.0.1:1234", address: "10.0.0.1"},
+		{remoteAddr: "10.0.0.1:1234", forwardedFor: "203.0.113.9", address: "203.0.113.9"},
+		{remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.7, 203.0.113.9, 10.0.0.2", address: "203.0.113.9"},
+		{remoteAddr: "10.0.0.1:1234", forwardedFor: "garbage, 10.0.0.2", address: "10.0.0.2"},
+	}
+
+	for _, test := range cases {
+		r := httptest.NewRequest("POST", "/oauth2/login", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		assert.Equal(t, test.address, clientAddress(c, r), test.forwardedFor)
+	}
+}
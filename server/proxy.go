@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/helpers"
+	"github.com/vedhavyas/oauth2_central/sessions"
+)
+
+// headerAccessToken carries the provider access token upstream when pass_access_token is enabled
+const headerAccessToken = "X-Forwarded-Access-Token"
+
+//ProxyHandler proxies the authenticated requests to the upstream configured for the path.
+//Requests not matching any upstream are handled by NotFoundHandler
+func ProxyHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := requestSnapshot(r)
+	upstream, ok := getUpstream(snapshot.Config, r.URL.Path)
+	if !ok {
+		NotFoundHandler(w, r)
+		return
+	}
+
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	providerName := upstream.Provider
+	if providerName == "" {
+		providerName = "google"
+	}
+
+	// client supplied identity headers must never reach the upstream
+	for _, header := range identityHeaders {
+		r.Header.Del(header)
+	}
+	r.Header.Del(headerAccessToken)
+
+	authRes, err := isAuthenticatedWith(w, r, providerName)
+	if err != nil {
+		if _, ok := err.(*helpers.UnRecoverableError); ok {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method != "GET" || !isBrowserRequest(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		http.Redirect(w, r, getStartURL(r, publicRequestURL(snapshot.Config, r), providerName), http.StatusFound)
+		return
+	}
+
+	setIdentityHeaders(r.Header, authRes)
+	if snapshot.Config.PassAccessToken {
+		session, err := snapshot.DefaultStore.Get(r, sessionCookieName(snapshot.Config))
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if accessToken, ok := session.Values[fmt.Sprintf("%s_access_token", providerName)].(string); ok {
+			r.Header.Set(headerAccessToken, accessToken)
+		}
+	}
+	removeCookie(r, sessionCookieName(snapshot.Config))
+
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+}
+
+// publicRequestURL returns the url the client requested. The scheme and host come from the external_url when it is set,
+// as the scheme is lost behind a TLS terminating proxy and the forwarded headers can't be trusted
+func publicRequestURL(c *config.Configuration, r *http.Request) *url.URL {
+	requestURL := &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	if r.TLS != nil {
+		requestURL.Scheme = "https"
+	}
+
+	if externalURL, err := url.Parse(c.ExternalURL); err == nil && externalURL.Host != "" {
+		requestURL.Scheme, requestURL.Host = externalURL.Scheme, externalURL.Host
+	}
+	return requestURL
+}
+
+// getUpstream returns the upstream with the longest path prefix matching the path
+func getUpstream(c *config.Configuration, path string) (config.UpstreamConfig, bool) {
+	var upstream config.UpstreamConfig
+	found := false
+	for _, candidate := range c.Upstreams {
+		if !matchesPathPrefix(path, candidate.Path) {
+			continue
+		}
+
+		if !found || len(candidate.Path) > len(upstream.Path) {
+			upstream = candidate
+			found = true
+		}
+	}
+	return upstream, found
+}
+
+// removeCookie drops the cookie and its chunks from the request so that the session doesn't leak to the upstream
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	var kept []string
+	for _, cookie := range cookies {
+		if !sessions.IsSessionCookie(name, cookie.Name) {
+			kept = append(kept, cookie.String())
+		}
+	}
+
+	if len(kept) > 0 {
+		r.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/sessions"
+)
+
+func newFakeIssuer() *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer valid_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"email":              "john@example.com",
+			"email_verified":     true,
+			"preferred_username": "john",
+		})
+	})
+	return server
+}
+
+// newSessionCookie returns the session cookie holding the access token of the provider
+func newSessionCookie(t *testing.T, providerName string, accessToken string) *http.Cookie {
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	session, err := sessions.Current().DefaultStore.Get(r, sessionCookieName(&config.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.Values[fmt.Sprintf("%s_access_token", providerName)] = accessToken
+	if err = session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Result().Cookies()[0]
+}
+
+func TestProxyHandler(t *testing.T) {
+	issuer := newFakeIssuer()
+	defer issuer.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":   r.URL.Path,
+			"email":  r.Header.Get(headerEmail),
+			"user":   r.Header.Get(headerUser),
+			"token":  r.Header.Get(headerAccessToken),
+			"cookie": r.Header.Get("Cookie"),
+		})
+	}))
+	defer upstream.Close()
+
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+	config.Config.CookieNameSpace = "test"
+	config.Config.CookieSecret = "a very long secret used to sign the test cookies"
+	config.Config.PassAccessToken = true
+	config.Config.Providers = []config.ProviderConfig{{Name: "test", Type: "oidc", IssuerURL: issuer.URL}}
+	config.Config.Upstreams = []config.UpstreamConfig{{Path: "/app", URL: upstream.URL, Provider: "test"}}
+	if err := sessions.InitiateStores(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path           string
+		cookie         *http.Cookie
+		headers        map[string]string
+		expectedStatus int
+		expectedBody   map[string]string
+	}{
+		{path: "/other", expectedStatus: http.StatusNotFound},
+		{path: "/app/page", expectedStatus: http.StatusUnauthorized},
+		{path: "/app/page", headers: map[string]string{"Accept": "text/html"}, expectedStatus: http.StatusFound},
+		{path: "/app/page", cookie: newSessionCookie(t, "test", "invalid_token"), expectedStatus: http.StatusUnauthorized},
+		{
+			path:           "/app/page",
+			cookie:         newSessionCookie(t, "test", "valid_token"),
+			headers:        map[string]string{headerEmail: "admin@example.com", "Cookie": "other=value"},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]string{
+				"path":   "/app/page",
+				"email":  "john@example.com",
+				"user":   "john",
+				"token":  "valid_token",
+				"cookie": "other=value",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		for key, value := range test.headers {
+			r.Header.Set(key, value)
+		}
+		if test.cookie != nil {
+			r.AddCookie(test.cookie)
+		}
+
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, r)
+		assert.Equal(t, test.expectedStatus, w.Code, test.path)
+		if test.expectedBody == nil {
+			continue
+		}
+
+		var body map[string]string
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, test.expectedBody, body)
+	}
+
+	// without upstreams the requests not matching the oauth2 routes are not found
+	config.Config.Upstreams = nil
+	assert.Nil(t, sessions.InitiateStores())
+	r := httptest.NewRequest("GET", "/app/page", nil)
+	r.AddCookie(newSessionCookie(t, "test", "valid_token"))
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_publicRequestURL(t *testing.T) {
+	cases := []struct {
+		config      config.Configuration
+		tls         bool
+		expectedURL string
+	}{
+		{expectedURL: "http://sso.example.com/app/page?q=1"},
+		{tls: true, expectedURL: "https://sso.example.com/app/page?q=1"},
+		{config: config.Configuration{ExternalURL: "https://sso.example.com/"}, expectedURL: "https://sso.example.com/app/page?q=1"},
+		{config: config.Configuration{ExternalURL: "https://public.example.com"}, expectedURL: "https://public.example.com/app/page?q=1"},
+	}
+
+	for _, test := range cases {
+		r := httptest.NewRequest("GET", "http://sso.example.com/app/page?q=1", nil)
+		r.Header.Set("X-Forwarded-Proto", "gopher")
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		assert.Equal(t, test.expectedURL, publicRequestURL(&test.config, r).String())
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/sessions"
+)
+
+// reloadLock serializes the reloads. The requests never wait on it, they read the published snapshot
+var reloadLock sync.Mutex
+
+// snapshotKey keys the snapshot of the request in the request context
+type snapshotKey struct{}
+
+// withSnapshot reads the snapshot in effect once at the start of the request, so that the request sees
+// a single configuration and the session stores built from it even if a reload replaces them meanwhile
+func withSnapshot(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), snapshotKey{}, sessions.Current())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestSnapshot returns the snapshot read at the start of the request, or the one in effect
+// for the requests not served through withSnapshot
+func requestSnapshot(r *http.Request) *sessions.Snapshot {
+	if snapshot, ok := r.Context().Value(snapshotKey{}).(*sessions.Snapshot); ok {
+		return snapshot
+	}
+
+	return sessions.Current()
+}
+
+//ReloadConfigFile reloads the configuration and re-initiates the session stores from it.
+//Both are published together once the stores are built, the requests in flight keep the snapshot they started with.
+//Reloads changing the settings only read on startup, the port, TLS and the session store backend, are rejected
+func ReloadConfigFile(configFile string) error {
+	loaded, err := config.ReadConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	if changed := sessions.Current().Config.RestartSettingsChanged(loaded); len(changed) > 0 {
+		return fmt.Errorf("%s can't be changed without a restart", strings.Join(changed, ", "))
+	}
+
+	snapshot, err := sessions.NewSnapshot(loaded)
+	if err != nil {
+		return err
+	}
+
+	sessions.Publish(snapshot)
+	log.Println("reloaded the configuration")
+	return nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/sessions"
+)
+
+// withConfig returns the request carrying a snapshot of the configuration, as if served through withSnapshot
+func withConfig(r *http.Request, c config.Configuration) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), snapshotKey{}, &sessions.Snapshot{Config: &c}))
+}
+
+// writeConfigFile writes the configuration to the file overriding the defaults with the given settings
+func writeConfigFile(t *testing.T, path string, settings map[string]interface{}) {
+	configuration := map[string]interface{}{
+		"cookie_name_space": "test",
+		"cookie_secret":     "a very long secret used to sign the test cookies",
+		"allowed_redirects": []string{"https://app.example.com"},
+		"assertion_secret":  "a very long secret used to sign the test assertions",
+		"providers":         []map[string]string{{"name": "local", "type": "local", "users_file": "users.json"}},
+	}
+	for name, value := range settings {
+		configuration[name] = value
+	}
+
+	data, err := json.Marshal(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config.Config
+	defer func() {
+		config.Config = savedConfig
+		sessions.InitiateStores()
+	}()
+
+	first, second := filepath.Join(dir, "first.json"), filepath.Join(dir, "second.json")
+	writeConfigFile(t, first, nil)
+	writeConfigFile(t, second, map[string]interface{}{
+		"cookie_secret":     "another very long secret used to sign the test cookies",
+		"allowed_redirects": []string{"https://app.example.com", "https://other.example.com"},
+	})
+	if err := config.LoadConfigFile(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.InitiateStores(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the requests in flight see a single configuration while it is reloaded, run with -race
+	routes := handler()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query := url.Values{"provider": {"local"}, "redirect_url": {"https://app.example.com/home"}}
+			for j := 0; j < 50; j++ {
+				w := httptest.NewRecorder()
+				routes.ServeHTTP(w, httptest.NewRequest("GET", "/oauth2/start?"+query.Encode(), nil))
+				assert.Equal(t, http.StatusFound, w.Code)
+
+				w = httptest.NewRecorder()
+				routes.ServeHTTP(w, httptest.NewRequest("GET", "/oauth2/userinfo", nil))
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+			}
+		}()
+	}
+
+	requestsDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(requestsDone)
+	}()
+
+	for reloading, reloads := true, 0; reloading; reloads++ {
+		select {
+		case <-requestsDone:
+			reloading = false
+		default:
+			configFile := first
+			if reloads%2 == 0 {
+				configFile = second
+			}
+			assert.NoError(t, ReloadConfigFile(configFile))
+		}
+	}
+	assert.NoError(t, ReloadConfigFile(first))
+	current := sessions.Current()
+	assert.Equal(t, "a very long secret used to sign the test cookies", current.Config.CookieSecret)
+
+	// failed reloads keep the current snapshot
+	cases := []struct {
+		name     string
+		settings map[string]interface{}
+		err      string
+	}{
+		{name: "session store", settings: map[string]interface{}{"session_store": "bolt"}, err: "session_store can't be changed without a restart"},
+		{name: "port", settings: map[string]interface{}{"port": "9090", "redis_address": "localhost:6379"}, err: "port, redis_address can't be changed without a restart"},
+		{name: "stores", settings: map[string]interface{}{"cookie_secret": "a rotated secret that is long enough to be accepted", "cookie_expires_in": "oneM"}, err: "invalid cookie_expires_in"},
+	}
+
+	for _, test := range cases {
+		writeConfigFile(t, second, test.settings)
+		err := ReloadConfigFile(second)
+		if assert.Error(t, err, test.name) {
+			assert.Contains(t, err.Error(), test.err, test.name)
+		}
+		assert.True(t, current == sessions.Current(), test.name)
+	}
+
+	assert.Error(t, ReloadConfigFile(filepath.Join(dir, "missing.json")))
+}
@@ -6,36 +6,36 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
-	"github.com/vedhavyas/oauth2_central/config"
 	"github.com/vedhavyas/oauth2_central/helpers"
+	"github.com/vedhavyas/oauth2_central/sessions"
 )
 
 // Router for the web service
 var Router = mux.NewRouter()
 
 func init() {
-	Router.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
+	// the requests not matching the oauth2 routes go to the upstreams, which falls back to NotFoundHandler
+	Router.NotFoundHandler = http.HandlerFunc(ProxyHandler)
 	Router.HandleFunc("/oauth2/start", StartAuthHandler).Methods("GET")
 	Router.HandleFunc("/oauth2/authenticate", AuthenticateHandler).Methods("GET")
+	Router.HandleFunc("/oauth2/userinfo", UserInfoHandler).Methods("GET", "OPTIONS")
 	Router.HandleFunc("/oauth2/callback", CallbackHandler).Methods("GET")
+	Router.HandleFunc("/oauth2/login", LoginHandler).Methods("GET", "POST")
+	Router.HandleFunc("/oauth2/logout", LogoutHandler)
 	Router.HandleFunc("/oauth2/ping", PingHandler).Methods("HEAD", "GET")
-
 }
 
 //ServeHTTP serves http API
 func ServeHTTP() {
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", config.Config.Port), helpers.LoggingHandler(Router)))
+	log.Fatal(http.ListenAndServe(listenAddress(), handler()))
 }
 
 //ServeHTTPSIfAvailable serves https API
 func ServeHTTPSIfAvailable() {
-	if config.Config.IsSecure() {
-		err := http.ListenAndServeTLS(
-			fmt.Sprintf(":%s", config.Config.Port),
-			config.Config.TLSCert,
-			config.Config.TLSKey,
-			helpers.LoggingHandler(Router))
-
+	// TLS is only read on startup, a reload can't change it
+	c := sessions.Current().Config
+	if c.IsSecure() {
+		err := http.ListenAndServeTLS(listenAddress(), c.TLSCert, c.TLSKey, handler())
 		if err != nil {
 			log.Fatal(err)
 			ServeHTTP()
@@ -46,3 +46,13 @@ func ServeHTTPSIfAvailable() {
 
 	ServeHTTP()
 }
+
+// listenAddress returns the address of the port, which a reload can't change
+func listenAddress() string {
+	return fmt.Sprintf(":%s", sessions.Current().Config.Port)
+}
+
+// handler is the Router with the request logging and the snapshot read for each request
+func handler() http.Handler {
+	return helpers.LoggingHandler(withSnapshot(Router))
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/providers"
+)
+
+// sessionProfile is the profile returned along with the tokens, by the providers that can't fetch it with the access token.
+// It is kept as JSON so that the session values stay plain strings for every session store
+type sessionProfile struct {
+	Profile   *providers.AuthResponse `json:"profile"`
+	ExpiresAt int64                   `json:"exp"`
+}
+
+func profileKey(providerName string) string {
+	return fmt.Sprintf("%s_profile", providerName)
+}
+
+// setSessionProfile keeps the profile of the redeem response in the session values until the tokens expire
+func setSessionProfile(values map[interface{}]interface{}, providerName string, redeemResponse *providers.RedeemResponse) error {
+	if redeemResponse.Profile == nil {
+		delete(values, profileKey(providerName))
+		return nil
+	}
+
+	data, err := json.Marshal(sessionProfile{Profile: redeemResponse.Profile, ExpiresAt: redeemResponse.ExpiresOn.Unix()})
+	if err != nil {
+		return err
+	}
+
+	values[profileKey(providerName)] = string(data)
+	return nil
+}
+
+// getSessionProfile returns the profile kept in the session values unless it expired
+func getSessionProfile(values map[interface{}]interface{}, providerName string, now time.Time) (*providers.AuthResponse, bool) {
+	data, ok := values[profileKey(providerName)].(string)
+	if !ok {
+		return nil, false
+	}
+
+	var profile sessionProfile
+	if err := json.Unmarshal([]byte(data), &profile); err != nil || profile.Profile == nil {
+		return nil, false
+	}
+
+	if now.Unix() >= profile.ExpiresAt {
+		return nil, false
+	}
+
+	return profile.Profile, true
+}
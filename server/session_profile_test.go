@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/providers"
+)
+
+func Test_sessionProfile(t *testing.T) {
+	now := time.Now()
+	profile := &providers.AuthResponse{Email: "john@contoso.com", Groups: []string{"a1b2c3"}, Roles: []string{"Reader"}}
+
+	tests := []struct {
+		profile   *providers.AuthResponse
+		expiresOn time.Time
+		found     bool
+	}{
+		{profile: profile, expiresOn: now.Add(time.Hour), found: true},
+		{profile: profile, expiresOn: now.Add(-time.Second)},
+
+		// the profile of the earlier tokens is dropped when the new ones come without one
+		{expiresOn: now.Add(time.Hour)},
+	}
+
+	for _, test := range tests {
+		values := map[interface{}]interface{}{profileKey("azure"): `{"profile":{"email":"stale@contoso.com"},"exp":9999999999}`}
+		err := setSessionProfile(values, "azure", &providers.RedeemResponse{Profile: test.profile, ExpiresOn: test.expiresOn})
+		assert.Nil(t, err)
+
+		got, found := getSessionProfile(values, "azure", now)
+		assert.Equal(t, test.found, found)
+		if test.found {
+			assert.Equal(t, test.profile, got)
+		}
+	}
+
+	// profiles are kept per provider and go with the session
+	values := map[interface{}]interface{}{}
+	setSessionProfile(values, "azure", &providers.RedeemResponse{Profile: profile, ExpiresOn: now.Add(time.Hour)})
+	_, found := getSessionProfile(values, "other", now)
+	assert.False(t, found)
+	expireSession(values, "azure")
+	_, found = getSessionProfile(values, "azure", now)
+	assert.False(t, found)
+}
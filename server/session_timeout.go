@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vedhavyas/oauth2_central/sessions"
+)
+
+// lastSeenResolution is how old the last seen time may get before it is updated.
+// It keeps the sliding idle window from rewriting the session on every request
+const lastSeenResolution = time.Minute
+
+func authenticatedAtKey(providerName string) string {
+	return fmt.Sprintf("%s_authenticated_at", providerName)
+}
+
+func lastSeenAtKey(providerName string) string {
+	return fmt.Sprintf("%s_last_seen_at", providerName)
+}
+
+// stampSession records the login with the provider in the session values
+func stampSession(values map[interface{}]interface{}, providerName string, now time.Time) {
+	values[authenticatedAtKey(providerName)] = now.Unix()
+	values[lastSeenAtKey(providerName)] = now.Unix()
+}
+
+// checkSessionTimeouts reports whether the provider session went idle or outlived its lifetime.
+// The idle window of a live session is slid forward, touched reports whether the values changed
+func checkSessionTimeouts(snapshot *sessions.Snapshot, values map[interface{}]interface{}, providerName string,
+	now time.Time) (expired, touched bool) {
+	if snapshot.IdleTimeout == 0 && snapshot.Lifetime == 0 {
+		return false, false
+	}
+
+	authenticatedAt, ok := values[authenticatedAtKey(providerName)].(int64)
+	if !ok {
+		// sessions created before the timeouts were enabled start their lifetime now
+		stampSession(values, providerName, now)
+		return false, true
+	}
+
+	if snapshot.Lifetime > 0 && now.Sub(time.Unix(authenticatedAt, 0)) > snapshot.Lifetime {
+		return true, false
+	}
+
+	lastSeenAt, _ := values[lastSeenAtKey(providerName)].(int64)
+	if snapshot.IdleTimeout == 0 {
+		return false, false
+	}
+
+	idle := now.Sub(time.Unix(lastSeenAt, 0))
+	if idle > snapshot.IdleTimeout {
+		return true, false
+	}
+
+	if idle < lastSeenResolution {
+		return false, false
+	}
+
+	values[lastSeenAtKey(providerName)] = now.Unix()
+	return false, true
+}
+
+// expireSession removes the tokens, the profile and the timestamps of the provider from the session values
+func expireSession(values map[interface{}]interface{}, providerName string) {
+	delete(values, fmt.Sprintf("%s_access_token", providerName))
+	delete(values, fmt.Sprintf("%s_refresh_token", providerName))
+	delete(values, profileKey(providerName))
+	delete(values, authenticatedAtKey(providerName))
+	delete(values, lastSeenAtKey(providerName))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/sessions"
+)
+
+func Test_checkSessionTimeouts(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		idleTimeout     time.Duration
+		lifetime        time.Duration
+		authenticatedAt time.Duration
+		lastSeenAt      time.Duration
+		expired         bool
+		touched         bool
+	}{
+		// disabled
+		{authenticatedAt: 48 * time.Hour, lastSeenAt: 48 * time.Hour},
+
+		// absolute lifetime
+		{lifetime: 12 * time.Hour, authenticatedAt: 11 * time.Hour},
+		{lifetime: 12 * time.Hour, authenticatedAt: 13 * time.Hour, expired: true},
+
+		// idle timeout slides with the use
+		{idleTimeout: time.Hour, authenticatedAt: 5 * time.Hour, lastSeenAt: 30 * time.Second},
+		{idleTimeout: time.Hour, authenticatedAt: 5 * time.Hour, lastSeenAt: 30 * time.Minute, touched: true},
+		{idleTimeout: time.Hour, authenticatedAt: 5 * time.Hour, lastSeenAt: 2 * time.Hour, expired: true},
+
+		// both
+		{idleTimeout: time.Hour, lifetime: 4 * time.Hour, authenticatedAt: 5 * time.Hour, lastSeenAt: time.Minute, expired: true},
+	}
+
+	for _, c := range tests {
+		snapshot := &sessions.Snapshot{IdleTimeout: c.idleTimeout, Lifetime: c.lifetime}
+		values := map[interface{}]interface{}{
+			"google_authenticated_at": now.Add(-c.authenticatedAt).Unix(),
+			"google_last_seen_at":     now.Add(-c.lastSeenAt).Unix(),
+		}
+
+		expired, touched := checkSessionTimeouts(snapshot, values, "google", now)
+		assert.Equal(t, c.expired, expired)
+		assert.Equal(t, c.touched, touched)
+		if touched {
+			assert.Equal(t, now.Unix(), values["google_last_seen_at"])
+		}
+	}
+
+	// sessions from before the timeouts start their lifetime now
+	values := map[interface{}]interface{}{}
+	expired, touched := checkSessionTimeouts(&sessions.Snapshot{IdleTimeout: time.Hour}, values, "google", now)
+	assert.False(t, expired)
+	assert.True(t, touched)
+	assert.Equal(t, now.Unix(), values["google_authenticated_at"])
+}
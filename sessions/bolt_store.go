@@ -0,0 +1,256 @@
+package sessions
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/vedhavyas/oauth2_central/config"
+	bolt "go.etcd.io/bbolt"
+)
+
+//BoltStore stores the sessions in an embedded BoltDB file.
+//The cookie only carries the signed session ID, the session values stay on the server
+type BoltStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	db      *bolt.DB
+	bucket  []byte
+}
+
+type boltRecord struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Data      string    `json:"data"`
+}
+
+//NewBoltStore returns a new BoltStore keeping its sessions in the bucket of db
+func NewBoltStore(db *bolt.DB, bucket string, keyPairs ...[]byte) (*BoltStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bs := &BoltStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		db:     db,
+		bucket: []byte(bucket),
+	}
+
+	bs.MaxAge(bs.Options.MaxAge)
+	return bs, nil
+}
+
+//Get returns a session for the given name after adding it to the registry
+func (s *BoltStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+//New returns a session for the given name without adding it to the registry.
+//Sessions missing from the database, revoked or expired, are returned as new sessions
+func (s *BoltStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+
+	if !found {
+		session.ID = ""
+		return session, nil
+	}
+
+	session.IsNew = false
+	return session, nil
+}
+
+//Save persists the session in the database and sets the session ID cookie.
+//Sessions with MaxAge < 0 are deleted from the database
+func (s *BoltStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(
+			base32.StdEncoding.EncodeToString(
+				securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+//MaxAge sets the maximum age for the store and the underlying cookie implementation
+func (s *BoltStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	setCodecsMaxAge(s.Codecs, age)
+}
+
+//DeleteExpired removes the expired sessions from the database
+func (s *BoltStore) DeleteExpired() error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(s.bucket).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil || now.After(record.ExpiresAt) {
+				if err = cursor.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	record, err := json.Marshal(boltRecord{
+		ExpiresAt: time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second),
+		Data:      encoded,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(session.ID), record)
+	})
+}
+
+func (s *BoltStore) load(session *sessions.Session) (bool, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(s.bucket).Get([]byte(session.ID)); data != nil {
+			value = append([]byte{}, data...)
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		return false, err
+	}
+
+	var record boltRecord
+	if err = json.Unmarshal(value, &record); err != nil {
+		return false, err
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		return false, s.delete(session.ID)
+	}
+
+	err = securecookie.DecodeMulti(session.Name(), record.Data, &session.Values, s.Codecs...)
+	return err == nil, err
+}
+
+func (s *BoltStore) delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(id))
+	})
+}
+
+// boltDB is opened once and shared by the default and short lived stores
+var boltDB *bolt.DB
+
+// boltReapInterval is how often expired sessions are removed from the database
+const boltReapInterval = time.Hour
+
+func newBoltStores(c *config.Configuration) (sessions.Store, sessions.Store, error) {
+	if boltDB == nil {
+		path := c.SessionStorePath
+		if path == "" {
+			path = "sessions.db"
+		}
+
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+		if err != nil {
+			return nil, nil, err
+		}
+		boltDB = db
+
+		// the reaper only needs the buckets, so the stores of a reloaded configuration share it
+		go reapExpired(boltDB, "sessions", "states")
+	}
+
+	defaultOptions, err := getDefaultOptions(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defaultStore, err := NewBoltStore(boltDB, "sessions")
+	if err != nil {
+		return nil, nil, err
+	}
+	if defaultStore.Codecs, err = getCodecs(c); err != nil {
+		return nil, nil, err
+	}
+	defaultStore.Options = defaultOptions
+	defaultStore.MaxAge(defaultStore.Options.MaxAge)
+
+	shortLiveStore, err := NewBoltStore(boltDB, "states")
+	if err != nil {
+		return nil, nil, err
+	}
+	if shortLiveStore.Codecs, err = getCodecs(c); err != nil {
+		return nil, nil, err
+	}
+	shortLiveStore.Options = getShortLiveOptions(c)
+	shortLiveStore.MaxAge(shortLiveStore.Options.MaxAge)
+	return defaultStore, shortLiveStore, nil
+}
+
+func reapExpired(db *bolt.DB, buckets ...string) {
+	for range time.Tick(boltReapInterval) {
+		for _, bucket := range buckets {
+			store := &BoltStore{db: db, bucket: []byte(bucket)}
+			if err := store.DeleteExpired(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package sessions
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestBoltStore(t *testing.T) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "bolt_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "sessions.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewBoltStore(db, "sessions", []byte("a very long secret used to sign the session ids"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.True(t, session.IsNew)
+
+	session.Values["google_access_token"] = "access_token"
+	w := httptest.NewRecorder()
+	assert.Nil(t, store.Save(r, w, session))
+
+	cookie := w.Result().Cookies()[0]
+	assert.NotContains(t, cookie.Value, "access_token")
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	session, err = store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "access_token", session.Values["google_access_token"])
+
+	// deleted sessions are gone from the database even if the cookie is replayed
+	session.Options.MaxAge = -1
+	assert.Nil(t, store.Save(r, httptest.NewRecorder(), session))
+	session, err = store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.True(t, session.IsNew)
+	assert.Empty(t, session.Values)
+
+	// forged session ids are rejected
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "test_oauth", Value: "forged"})
+	_, err = store.New(r, "test_oauth")
+	assert.NotNil(t, err)
+}
+
+func TestBoltStore_DeleteExpired(t *testing.T) {
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	live, _ := store.New(r, "live")
+	assert.Nil(t, store.Save(r, httptest.NewRecorder(), live))
+
+	expired, _ := store.New(r, "expired")
+	expired.Options.MaxAge = 0
+	assert.Nil(t, store.Save(r, httptest.NewRecorder(), expired))
+
+	assert.Nil(t, store.DeleteExpired())
+	count := 0
+	store.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(store.bucket).Stats().KeyN
+		return nil
+	})
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,154 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// maxCookieValueSize keeps each cookie, along with its name and attributes, under the 4096 bytes browsers accept
+const maxCookieValueSize = 3800
+
+//ChunkedCookieStore keeps the sessions in the cookies. Sessions too large for a single cookie
+//are split into numbered chunk cookies, name_0, name_1..., which are joined back on read
+type ChunkedCookieStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+//NewChunkedCookieStore returns a new ChunkedCookieStore signing the sessions with the keyPairs
+func NewChunkedCookieStore(keyPairs ...[]byte) *ChunkedCookieStore {
+	cs := &ChunkedCookieStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+
+	// the chunks take care of the browser limits
+	for _, codec := range cs.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxLength(0)
+		}
+	}
+
+	cs.MaxAge(cs.Options.MaxAge)
+	return cs
+}
+
+//Get returns a session for the given name after adding it to the registry
+func (s *ChunkedCookieStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+//New returns a session for the given name without adding it to the registry
+func (s *ChunkedCookieStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	value, ok := readChunkedCookie(r, name)
+	if !ok {
+		return session, nil
+	}
+
+	err := securecookie.DecodeMulti(name, value, &session.Values, s.Codecs...)
+	if err == nil {
+		session.IsNew = false
+	}
+
+	return session, err
+}
+
+//Save writes the session in as many cookies as needed and removes the chunks no longer used.
+//Sessions with MaxAge < 0 are removed along with all their chunks
+func (s *ChunkedCookieStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	var chunks []string
+	if session.Options.MaxAge >= 0 {
+		encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+		if err != nil {
+			return err
+		}
+
+		chunks = splitChunks(encoded, maxCookieValueSize)
+	}
+
+	written := make(map[string]bool)
+	if len(chunks) == 1 {
+		http.SetCookie(w, sessions.NewCookie(session.Name(), chunks[0], session.Options))
+		written[session.Name()] = true
+	} else {
+		for i, chunk := range chunks {
+			chunkName := fmt.Sprintf("%s_%d", session.Name(), i)
+			http.SetCookie(w, sessions.NewCookie(chunkName, chunk, session.Options))
+			written[chunkName] = true
+		}
+	}
+
+	// remove the cookie or the chunks left over from a larger session
+	expired := *session.Options
+	expired.MaxAge = -1
+	for _, c := range r.Cookies() {
+		if IsSessionCookie(session.Name(), c.Name) && !written[c.Name] {
+			http.SetCookie(w, sessions.NewCookie(c.Name, "", &expired))
+			written[c.Name] = true
+		}
+	}
+
+	return nil
+}
+
+//MaxAge sets the maximum age for the store and the underlying cookie implementation
+func (s *ChunkedCookieStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	setCodecsMaxAge(s.Codecs, age)
+}
+
+//IsSessionCookie reports whether the cookie is the named session cookie or one of its chunks
+func IsSessionCookie(name, cookieName string) bool {
+	if cookieName == name {
+		return true
+	}
+
+	if !strings.HasPrefix(cookieName, name+"_") {
+		return false
+	}
+
+	_, err := strconv.Atoi(cookieName[len(name)+1:])
+	return err == nil
+}
+
+// readChunkedCookie returns the value of the named cookie or its chunks joined in order
+func readChunkedCookie(r *http.Request, name string) (string, bool) {
+	if c, err := r.Cookie(name); err == nil {
+		return c.Value, true
+	}
+
+	var chunks []string
+	for i := 0; ; i++ {
+		c, err := r.Cookie(fmt.Sprintf("%s_%d", name, i))
+		if err != nil {
+			break
+		}
+		chunks = append(chunks, c.Value)
+	}
+
+	return strings.Join(chunks, ""), len(chunks) > 0
+}
+
+// splitChunks splits the value into chunks of at most size bytes
+func splitChunks(value string, size int) []string {
+	var chunks []string
+	for len(value) > size {
+		chunks = append(chunks, value[:size])
+		value = value[size:]
+	}
+
+	return append(chunks, value)
+}
@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sendCookies returns a request carrying the cookies the response left in the browser
+func sendCookies(jar map[string]*http.Cookie, w *httptest.ResponseRecorder) *http.Request {
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(jar, c.Name)
+			continue
+		}
+		jar[c.Name] = c
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range jar {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return r
+}
+
+func TestChunkedCookieStore(t *testing.T) {
+	store := NewChunkedCookieStore([]byte("a very long secret used to sign the sessions"))
+	jar := make(map[string]*http.Cookie)
+
+	// large sessions are split into chunks
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	session.Values["google_id_token"] = strings.Repeat("token", 2000)
+	w := httptest.NewRecorder()
+	assert.Nil(t, store.Save(r, w, session))
+
+	r = sendCookies(jar, w)
+	assert.NotContains(t, jar, "test_oauth")
+	assert.Contains(t, jar, "test_oauth_0")
+	assert.Contains(t, jar, "test_oauth_1")
+	for _, c := range jar {
+		assert.True(t, len(c.String()) < 4096)
+	}
+
+	session, err = store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, strings.Repeat("token", 2000), session.Values["google_id_token"])
+
+	// stale chunks are removed once the session shrinks
+	session.Values["google_id_token"] = "token"
+	w = httptest.NewRecorder()
+	assert.Nil(t, store.Save(r, w, session))
+	r = sendCookies(jar, w)
+	assert.Len(t, jar, 1)
+	assert.Contains(t, jar, "test_oauth")
+
+	session, err = store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.Equal(t, "token", session.Values["google_id_token"])
+
+	// deleted sessions remove all the cookies
+	session.Options.MaxAge = -1
+	w = httptest.NewRecorder()
+	assert.Nil(t, store.Save(r, w, session))
+	sendCookies(jar, w)
+	assert.Empty(t, jar)
+}
+
+func TestIsSessionCookie(t *testing.T) {
+	tests := []struct {
+		cookieName string
+		result     bool
+	}{
+		{cookieName: "test_oauth", result: true},
+		{cookieName: "test_oauth_0", result: true},
+		{cookieName: "test_oauth_12", result: true},
+		{cookieName: "test_oauth_state", result: false},
+		{cookieName: "test_oauth2", result: false},
+		{cookieName: "other", result: false},
+	}
+
+	for _, c := range tests {
+		assert.Equal(t, c.result, IsSessionCookie("test_oauth", c.cookieName), c.cookieName)
+	}
+}
@@ -1,53 +1,303 @@
 package sessions
 
 import (
-	"log"
+	"encoding/base64"
+	"fmt"
+	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/vedhavyas/oauth2_central/config"
+	"github.com/vedhavyas/oauth2_central/utilities"
 )
 
-//DefaultCookieStore is used to create and use long live cookie
-var DefaultCookieStore *sessions.CookieStore
+//Snapshot is the configuration in effect along with the session stores built from it.
+//It is published as a whole on startup and on reload and never modified after,
+//so that a request reads it once and uses the same configuration and stores throughout
+type Snapshot struct {
+	Config *config.Configuration
 
-//ShortLiveCookie is used to create and use short live state management cookie
-var ShortLiveCookie *sessions.CookieStore
+	//DefaultStore is used to create and use long live sessions
+	DefaultStore sessions.Store
 
-//InitiateCookieStores initiate Default and short lived cookies
-func InitiateCookieStores() {
-	DefaultCookieStore = sessions.NewCookieStore([]byte(config.Config.CookieSecret))
-	DefaultCookieStore.Options = getDefaultOptions()
-	ShortLiveCookie = sessions.NewCookieStore([]byte(config.Config.CookieSecret))
-	ShortLiveCookie.Options = getShortLiveOptions()
+	//ShortLiveStore is used to create and use short live state management sessions
+	ShortLiveStore sessions.Store
+
+	//IdleTimeout is how long a session may go unused before a fresh login is required. 0 disables it
+	IdleTimeout time.Duration
+
+	//Lifetime is how long a session lasts after the login regardless of its use. 0 disables it
+	Lifetime time.Duration
+}
+
+// current holds the *Snapshot in effect
+var current atomic.Value
+
+//Current returns the snapshot in effect, nil until the stores are initiated. It must not be modified
+func Current() *Snapshot {
+	snapshot, _ := current.Load().(*Snapshot)
+	return snapshot
+}
+
+//Publish makes the snapshot the one in effect for the requests starting after
+func Publish(snapshot *Snapshot) {
+	current.Store(snapshot)
+}
+
+//InitiateStores builds the snapshot of config.Config and publishes it
+func InitiateStores() error {
+	snapshot, err := NewSnapshot(config.Config)
+	if err != nil {
+		return err
+	}
+
+	Publish(snapshot)
+	return nil
+}
+
+//NewSnapshot initiates the Default and short lived stores of the configured session_store.
+//cookie keeps the sessions in the cookies, bolt keeps them in an embedded database file
+//and redis keeps them in Redis shared by all the replicas.
+//The snapshot keeps its own copy of the configuration
+func NewSnapshot(c config.Configuration) (*Snapshot, error) {
+	idleTimeout, lifetime, err := getTimeouts(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	var defaultStore, shortLiveStore sessions.Store
+	switch c.SessionStore {
+	case "", "cookie":
+		defaultStore, shortLiveStore, err = newCookieStores(&c)
+	case "bolt":
+		defaultStore, shortLiveStore, err = newBoltStores(&c)
+	case "redis":
+		defaultStore, shortLiveStore, err = newRedisStores(&c)
+	default:
+		return nil, fmt.Errorf("unknown session_store %q", c.SessionStore)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.CookieDomains) > 0 {
+		defaultStore = &domainStore{Store: defaultStore, domains: c.CookieDomains}
+		shortLiveStore = &domainStore{Store: shortLiveStore, domains: c.CookieDomains}
+	}
+
+	return &Snapshot{
+		Config:         &c,
+		DefaultStore:   defaultStore,
+		ShortLiveStore: shortLiveStore,
+		IdleTimeout:    idleTimeout,
+		Lifetime:       lifetime,
+	}, nil
+}
+
+func newCookieStores(c *config.Configuration) (sessions.Store, sessions.Store, error) {
+	defaultCodecs, err := getCodecs(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shortLiveCodecs, err := getCodecs(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defaultOptions, err := getDefaultOptions(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defaultStore := &ChunkedCookieStore{Codecs: defaultCodecs, Options: defaultOptions}
+	defaultStore.MaxAge(defaultStore.Options.MaxAge)
+
+	shortLiveStore := &ChunkedCookieStore{Codecs: shortLiveCodecs, Options: getShortLiveOptions(c)}
+	shortLiveStore.MaxAge(shortLiveStore.Options.MaxAge)
+	return defaultStore, shortLiveStore, nil
+}
+
+// getCodecs returns the codecs encrypting the sessions with AES-GCM, newest key first.
+// The first codec encodes while all of them are tried when decoding so that the rotated keys are still accepted.
+// Sessions only signed with the cookie secrets by the earlier releases are accepted and are encrypted on their next save
+func getCodecs(c *config.Configuration) ([]securecookie.Codec, error) {
+	keys, err := getEncryptionKeys(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var codecs []securecookie.Codec
+	for _, key := range keys {
+		gcmCodec, err := NewGCMCodec(key)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, gcmCodec)
+	}
+
+	for _, secret := range c.GetCookieSecrets() {
+		codecs = append(codecs, securecookie.New([]byte(secret), nil))
+	}
+
+	return codecs, nil
+}
+
+// getEncryptionKeys returns the configured cookie encryption keys or derives them from the cookie secrets
+func getEncryptionKeys(c *config.Configuration) ([][]byte, error) {
+	var keys [][]byte
+	encodedKeys := c.GetCookieEncryptionKeys()
+	if len(encodedKeys) == 0 {
+		for _, secret := range c.GetCookieSecrets() {
+			keys = append(keys, utilities.DeriveKey([]byte(secret), "oauth2_central cookie encryption", 32))
+		}
+		return keys, nil
+	}
+
+	for _, encodedKey := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+//IsStale reports whether the session cookie of the request was encoded with a rotated key.
+//Such sessions should be saved again so that their cookie is re-issued with the current key
+func IsStale(r *http.Request, store sessions.Store, name string) bool {
+	value, ok := readChunkedCookie(r, name)
+	if !ok {
+		return false
+	}
+
+	// the cookie store encodes the session values, the server side stores only the session ID
+	var codecs []securecookie.Codec
+	var dst interface{}
+	switch s := store.(type) {
+	case *domainStore:
+		return IsStale(r, s.Store, name)
+	case *ChunkedCookieStore:
+		codecs, dst = s.Codecs, &map[interface{}]interface{}{}
+	case *BoltStore:
+		codecs, dst = s.Codecs, new(string)
+	case *RedisStore:
+		codecs, dst = s.Codecs, new(string)
+	default:
+		return false
+	}
+
+	if len(codecs) < 2 || codecs[0].Decode(name, value, dst) == nil {
+		return false
+	}
+
+	return securecookie.DecodeMulti(name, value, dst, codecs[1:]...) == nil
 }
 
-func getDefaultOptions() *sessions.Options {
-	timeString := config.Config.CookieExpiresIn
+// setCodecsMaxAge sets the maximum age of the values for each codec
+func setCodecsMaxAge(codecs []securecookie.Codec, age int) {
+	for _, codec := range codecs {
+		switch c := codec.(type) {
+		case *securecookie.SecureCookie:
+			c.MaxAge(age)
+		case *GCMCodec:
+			c.MaxAge(age)
+		}
+	}
+}
+
+func getDefaultOptions(c *config.Configuration) (*sessions.Options, error) {
+	timeString := c.CookieExpiresIn
 	if timeString == "" {
 		timeString = "1M"
 	}
-	timeUnit := timeString[len(timeString)-1:]
-	unitValue, err := strconv.Atoi(timeString[:len(timeString)-1])
+	maxAge, err := parseExpiry(timeString)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("invalid cookie_expires_in: %v", err)
 	}
 
 	return &sessions.Options{
-		Path:     "/",
-		MaxAge:   unitValue * getUnitValue(timeUnit),
-		HttpOnly: config.Config.CookieHTTPOnly,
-		Secure:   config.Config.CookieSecure,
-	}
+		Path:     getCookiePath(c),
+		MaxAge:   maxAge,
+		HttpOnly: c.CookieHTTPOnly,
+		Secure:   c.CookieSecure,
+		SameSite: getSameSite(c),
+	}, nil
 }
 
-func getShortLiveOptions() *sessions.Options {
+func getShortLiveOptions(c *config.Configuration) *sessions.Options {
+	// the state cookie must reach the callback, which is a cross site navigation from the provider
+	sameSite := getSameSite(c)
+	if sameSite == http.SameSiteStrictMode {
+		sameSite = http.SameSiteLaxMode
+	}
+
 	return &sessions.Options{
-		Path:     "/",
+		Path:     getCookiePath(c),
 		MaxAge:   1 * getUnitValue("h"),
-		HttpOnly: config.Config.CookieHTTPOnly,
-		Secure:   config.Config.CookieSecure,
+		HttpOnly: c.CookieHTTPOnly,
+		Secure:   c.CookieSecure,
+		SameSite: sameSite,
+	}
+}
+
+func getCookiePath(c *config.Configuration) string {
+	if c.CookiePath == "" {
+		return "/"
+	}
+
+	return c.CookiePath
+}
+
+func getSameSite(c *config.Configuration) http.SameSite {
+	switch c.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func getTimeouts(c *config.Configuration) (idleTimeout, lifetime time.Duration, err error) {
+	idleTimeout, err = parseTimeout(c.SessionIdleTimeout)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid session_idle_timeout: %v", err)
+	}
+
+	lifetime, err = parseTimeout(c.SessionLifetime)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid session_lifetime: %v", err)
+	}
+
+	return idleTimeout, lifetime, nil
+}
+
+// parseTimeout parses the timeouts given in the cookie_expires_in format. Empty disables the timeout
+func parseTimeout(timeString string) (time.Duration, error) {
+	if timeString == "" {
+		return 0, nil
+	}
+
+	seconds, err := parseExpiry(timeString)
+	return time.Duration(seconds) * time.Second, err
+}
+
+// parseExpiry returns the seconds in the time string, ex: 30m, 12h, 1M
+func parseExpiry(timeString string) (int, error) {
+	timeUnit := timeString[len(timeString)-1:]
+	unitValue, err := strconv.Atoi(timeString[:len(timeString)-1])
+	if err != nil {
+		return 0, err
 	}
+
+	return unitValue * getUnitValue(timeUnit), nil
 }
 
 func getUnitValue(unit string) int {
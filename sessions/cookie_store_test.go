@@ -0,0 +1,54 @@
+package sessions
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+func TestCookieStore_KeyRotation(t *testing.T) {
+	savedConfig := config.Config
+	defer func() { config.Config = savedConfig }()
+
+	oldSecret := "the old secret at least 32 bytes long"
+	newSecret := "the new secret at least 32 bytes long"
+
+	config.Config.CookieSecrets = []string{oldSecret}
+	assert.Nil(t, InitiateStores())
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := Current().DefaultStore.Get(r, "test_oauth")
+	session.Values["google_access_token"] = "access_token"
+	w := httptest.NewRecorder()
+	assert.Nil(t, session.Save(r, w))
+	oldCookie := w.Result().Cookies()[0]
+
+	// the rotated key is still accepted but the cookie is stale
+	config.Config.CookieSecrets = []string{newSecret, oldSecret}
+	assert.Nil(t, InitiateStores())
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	session, err := Current().DefaultStore.Get(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.Equal(t, "access_token", session.Values["google_access_token"])
+	assert.True(t, IsStale(r, Current().DefaultStore, "test_oauth"))
+
+	// saving re-issues the cookie with the newest key
+	w = httptest.NewRecorder()
+	assert.Nil(t, session.Save(r, w))
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	assert.False(t, IsStale(r, Current().DefaultStore, "test_oauth"))
+
+	// once the old key is dropped, its cookies are rejected
+	config.Config.CookieSecrets = []string{newSecret}
+	assert.Nil(t, InitiateStores())
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	_, err = Current().DefaultStore.Get(r, "test_oauth")
+	assert.NotNil(t, err)
+}
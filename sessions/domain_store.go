@@ -0,0 +1,53 @@
+package sessions
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// domainStore scopes the session cookies to the configured cookie domain the request host belongs to,
+// so that the sessions are shared by its subdomains. Hosts outside the cookie domains get host only cookies
+type domainStore struct {
+	sessions.Store
+	domains []string
+}
+
+// Get returns a session for the given name after adding it to the registry
+func (s *domainStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name with the cookie domain of the request host
+func (s *domainStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, err := s.Store.New(r, name)
+	if session != nil {
+		session.Options.Domain = getCookieDomain(r.Host, s.domains)
+	}
+
+	return session, err
+}
+
+// getCookieDomain returns the most specific domain the host belongs to, empty if none
+func getCookieDomain(host string, domains []string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	var cookieDomain string
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+
+		if len(domain) > len(cookieDomain) {
+			cookieDomain = domain
+		}
+	}
+
+	return cookieDomain
+}
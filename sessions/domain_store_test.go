@@ -0,0 +1,47 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getCookieDomain(t *testing.T) {
+	domains := []string{".mydomain.com", "eu.mydomain.com"}
+	tests := []struct {
+		host   string
+		result string
+	}{
+		{host: "mydomain.com", result: "mydomain.com"},
+		{host: "app.mydomain.com:8080", result: "mydomain.com"},
+		{host: "App.EU.mydomain.com", result: "eu.mydomain.com"},
+		{host: "notmydomain.com", result: ""},
+		{host: "localhost", result: ""},
+	}
+
+	for _, c := range tests {
+		assert.Equal(t, c.result, getCookieDomain(c.host, domains), c.host)
+	}
+}
+
+func TestDomainStore(t *testing.T) {
+	inner := sessions.NewCookieStore([]byte("a very long secret used to sign the sessions"))
+	inner.Options.SameSite = http.SameSiteLaxMode
+	store := &domainStore{Store: inner, domains: []string{"mydomain.com"}}
+
+	r := httptest.NewRequest("GET", "http://app.mydomain.com/", nil)
+	session, err := store.Get(r, "test_oauth")
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	assert.Nil(t, session.Save(r, w))
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, "Domain=mydomain.com")
+	assert.Contains(t, cookie, "SameSite=Lax")
+
+	// the store options are left untouched
+	assert.Empty(t, inner.Options.Domain)
+}
@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"time"
+)
+
+// timestampLength is the size of the creation time sealed along with the value
+const timestampLength = 8
+
+var (
+	errGCMDecrypt = errors.New("gcm codec: the value could not be decrypted")
+	errGCMExpired = errors.New("gcm codec: expired timestamp")
+)
+
+//GCMCodec encrypts and authenticates the session values with AES-GCM.
+//The cookie name is used as additional data so a value can't be moved to another cookie.
+//It implements securecookie.Codec
+type GCMCodec struct {
+	aead   cipher.AEAD
+	maxAge int64
+}
+
+//NewGCMCodec returns a GCMCodec for the 16, 24 or 32 bytes long key
+func NewGCMCodec(key []byte) (*GCMCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCMCodec{aead: aead, maxAge: 86400 * 30}, nil
+}
+
+//MaxAge restricts the maximum age, in seconds, of the encoded value. 0 means no limit
+func (c *GCMCodec) MaxAge(age int) {
+	c.maxAge = int64(age)
+}
+
+//Encode serializes and encrypts the value
+func (c *GCMCodec) Encode(name string, value interface{}) (string, error) {
+	plaintext := make([]byte, timestampLength)
+	binary.BigEndian.PutUint64(plaintext, uint64(time.Now().Unix()))
+
+	buffer := bytes.NewBuffer(plaintext)
+	if err := gob.NewEncoder(buffer).Encode(value); err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, buffer.Bytes(), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+//Decode decrypts and deserializes the value into dst
+func (c *GCMCodec) Decode(name, value string, dst interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return errGCMDecrypt
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil || len(plaintext) < timestampLength {
+		return errGCMDecrypt
+	}
+
+	createdAt := int64(binary.BigEndian.Uint64(plaintext[:timestampLength]))
+	if c.maxAge != 0 && createdAt < time.Now().Unix()-c.maxAge {
+		return errGCMExpired
+	}
+
+	return gob.NewDecoder(bytes.NewReader(plaintext[timestampLength:])).Decode(dst)
+}
@@ -0,0 +1,76 @@
+package sessions
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCMCodec(t *testing.T) {
+	codec, err := NewGCMCodec([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[interface{}]interface{}{"google_access_token": "access_token"}
+	encoded, err := codec.Encode("test_oauth", values)
+	assert.Nil(t, err)
+	assert.NotContains(t, encoded, "access_token")
+
+	var decoded map[interface{}]interface{}
+	assert.Nil(t, codec.Decode("test_oauth", encoded, &decoded))
+	assert.Equal(t, values, decoded)
+
+	// values are bound to the cookie name
+	assert.NotNil(t, codec.Decode("other_oauth", encoded, &decoded))
+
+	// tampered values are rejected
+	tampered := strings.Replace(encoded, encoded[10:11], "A", 1)
+	if tampered == encoded {
+		tampered = strings.Replace(encoded, encoded[10:11], "B", 1)
+	}
+	assert.NotNil(t, codec.Decode("test_oauth", tampered, &decoded))
+
+	// values sealed with another key are rejected
+	other, _ := NewGCMCodec([]byte("fedcba9876543210fedcba9876543210"))
+	assert.NotNil(t, other.Decode("test_oauth", encoded, &decoded))
+
+	_, err = NewGCMCodec([]byte("short"))
+	assert.NotNil(t, err)
+}
+
+func TestGCMCodec_MaxAge(t *testing.T) {
+	codec, _ := NewGCMCodec([]byte("0123456789abcdef"))
+	encoded, err := codec.Encode("test_oauth", "value")
+	assert.Nil(t, err)
+
+	codec.MaxAge(-1)
+	var decoded string
+	assert.Equal(t, errGCMExpired, codec.Decode("test_oauth", encoded, &decoded))
+
+	codec.MaxAge(0)
+	assert.Nil(t, codec.Decode("test_oauth", encoded, &decoded))
+	assert.Equal(t, "value", decoded)
+}
+
+func TestGCMCodec_LegacySignedValues(t *testing.T) {
+	secret := []byte("a very long secret used to sign the session ids")
+	legacy := securecookie.New(secret, nil)
+	encoded, err := legacy.Encode("test_oauth", "value")
+	assert.Nil(t, err)
+
+	gcmCodec, _ := NewGCMCodec([]byte("0123456789abcdef"))
+	codecs := []securecookie.Codec{gcmCodec, securecookie.New(secret, nil)}
+
+	// values signed by the earlier releases are still accepted
+	var decoded string
+	assert.Nil(t, securecookie.DecodeMulti("test_oauth", encoded, &decoded, codecs...))
+	assert.Equal(t, "value", decoded)
+
+	// and are encrypted on their next save
+	encoded, err = securecookie.EncodeMulti("test_oauth", "value", codecs...)
+	assert.Nil(t, err)
+	assert.Nil(t, gcmCodec.Decode("test_oauth", encoded, &decoded))
+}
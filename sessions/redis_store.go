@@ -0,0 +1,227 @@
+package sessions
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/vedhavyas/oauth2_central/config"
+)
+
+//RedisStore stores the sessions in Redis so that they are shared between the replicas.
+//The cookie only carries the signed session ID and the keys expire along with the session
+type RedisStore struct {
+	Codecs    []securecookie.Codec
+	Options   *sessions.Options
+	pool      *redis.Pool
+	keyPrefix string
+}
+
+//NewRedisStore returns a new RedisStore keeping its sessions under keyPrefix
+func NewRedisStore(pool *redis.Pool, keyPrefix string, keyPairs ...[]byte) *RedisStore {
+	rs := &RedisStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		pool:      pool,
+		keyPrefix: keyPrefix,
+	}
+
+	rs.MaxAge(rs.Options.MaxAge)
+	return rs
+}
+
+//Get returns a session for the given name after adding it to the registry
+func (s *RedisStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+//New returns a session for the given name without adding it to the registry.
+//Sessions missing from Redis, revoked or expired, are returned as new sessions
+func (s *RedisStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+
+	if !found {
+		session.ID = ""
+		return session, nil
+	}
+
+	session.IsNew = false
+	return session, nil
+}
+
+//Save persists the session in Redis and sets the session ID cookie.
+//Sessions with MaxAge < 0 are deleted from Redis
+func (s *RedisStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.delete(session); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(
+			base32.StdEncoding.EncodeToString(
+				securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+//MaxAge sets the maximum age for the store and the underlying cookie implementation
+func (s *RedisStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	setCodecsMaxAge(s.Codecs, age)
+}
+
+func (s *RedisStore) key(session *sessions.Session) string {
+	return fmt.Sprintf("%s:%s:%s", s.keyPrefix, session.Name(), session.ID)
+}
+
+func (s *RedisStore) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	// redis doesn't accept a zero expiry, such sessions live only for the browser session
+	ttl := session.Options.MaxAge
+	if ttl <= 0 {
+		ttl = s.Options.MaxAge
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", s.key(session), encoded, "EX", ttl)
+	return err
+}
+
+func (s *RedisStore) load(session *sessions.Session) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.String(conn.Do("GET", s.key(session)))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = securecookie.DecodeMulti(session.Name(), data, &session.Values, s.Codecs...)
+	return err == nil, err
+}
+
+func (s *RedisStore) delete(session *sessions.Session) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", s.key(session))
+	return err
+}
+
+// redisPool is created once and shared by the default and short lived stores
+var redisPool *redis.Pool
+
+func newRedisPool(c *config.Configuration) *redis.Pool {
+	maxIdle := c.RedisPoolSize
+	if maxIdle <= 0 {
+		maxIdle = 10
+	}
+
+	// the connection settings are read once, they can't be changed by a reload
+	address, password, db := c.RedisAddress, c.RedisPassword, c.RedisDB
+
+	return &redis.Pool{
+		MaxIdle:     maxIdle,
+		MaxActive:   maxIdle * 10,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address,
+				redis.DialPassword(password),
+				redis.DialDatabase(db),
+				redis.DialConnectTimeout(5*time.Second))
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+func newRedisStores(c *config.Configuration) (sessions.Store, sessions.Store, error) {
+	if redisPool == nil {
+		pool := newRedisPool(c)
+		conn := pool.Get()
+		_, err := conn.Do("PING")
+		conn.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		redisPool = pool
+	}
+
+	defaultOptions, err := getDefaultOptions(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPrefix := c.CookieNameSpace
+	defaultStore := NewRedisStore(redisPool, keyPrefix+":session")
+	defaultStore.Codecs, err = getCodecs(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaultStore.Options = defaultOptions
+	defaultStore.MaxAge(defaultStore.Options.MaxAge)
+
+	shortLiveStore := NewRedisStore(redisPool, keyPrefix+":state")
+	shortLiveStore.Codecs, err = getCodecs(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	shortLiveStore.Options = getShortLiveOptions(c)
+	shortLiveStore.MaxAge(shortLiveStore.Options.MaxAge)
+	return defaultStore, shortLiveStore, nil
+}
@@ -0,0 +1,157 @@
+package sessions
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis is an in-process stand-in speaking just enough RESP for the RedisStore
+type fakeRedis struct {
+	sync.Mutex
+	listener net.Listener
+	values   map[string]string
+	expiries map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeRedis{listener: listener, values: map[string]string{}, expiries: map[string]time.Time{}}
+	go fake.serve()
+	return fake
+}
+
+func (fake *fakeRedis) serve() {
+	for {
+		conn, err := fake.listener.Accept()
+		if err != nil {
+			return
+		}
+		go fake.handle(conn)
+	}
+}
+
+func (fake *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte(fake.execute(args)))
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		if _, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func (fake *fakeRedis) execute(args []string) string {
+	fake.Lock()
+	defer fake.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SET":
+		fake.values[args[1]] = args[2]
+		ttl, _ := strconv.Atoi(args[4])
+		fake.expiries[args[1]] = time.Now().Add(time.Duration(ttl) * time.Second)
+		return "+OK\r\n"
+	case "GET":
+		value, ok := fake.values[args[1]]
+		if !ok || time.Now().After(fake.expiries[args[1]]) {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "DEL":
+		delete(fake.values, args[1])
+		return ":1\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	fake := newFakeRedis(t)
+	defer fake.listener.Close()
+
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", fake.listener.Addr().String())
+	}}
+	defer pool.Close()
+
+	store := NewRedisStore(pool, "test:session", []byte("a very long secret used to sign the session ids"))
+	store.MaxAge(3600)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.True(t, session.IsNew)
+
+	session.Values["google_access_token"] = "access_token"
+	w := httptest.NewRecorder()
+	assert.Nil(t, store.Save(r, w, session))
+
+	cookie := w.Result().Cookies()[0]
+	assert.NotContains(t, cookie.Value, "access_token")
+	assert.Contains(t, fake.values, "test:session:test_oauth:"+session.ID)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), fake.expiries["test:session:test_oauth:"+session.ID], time.Minute)
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	session, err = store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "access_token", session.Values["google_access_token"])
+
+	// deleted sessions are gone from redis even if the cookie is replayed
+	session.Options.MaxAge = -1
+	assert.Nil(t, store.Save(r, httptest.NewRecorder(), session))
+	assert.Empty(t, fake.values)
+	session, err = store.New(r, "test_oauth")
+	assert.Nil(t, err)
+	assert.True(t, session.IsNew)
+
+	// forged session ids are rejected
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "test_oauth", Value: "forged"})
+	_, err = store.New(r, "test_oauth")
+	assert.NotNil(t, err)
+}
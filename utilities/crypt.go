@@ -1,7 +1,9 @@
 package utilities
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 )
 
@@ -21,3 +23,23 @@ func GenerateRandomString(n int) (string, error) {
 	b, err := generateRandomBytes(n)
 	return base64.URLEncoding.EncodeToString(b), err
 }
+
+//DeriveKey derives a key of length bytes from the secret using HKDF-SHA256 (RFC 5869).
+//info separates the keys derived from the same secret for different purposes
+func DeriveKey(secret []byte, info string, length int) []byte {
+	extractor := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extractor.Write(secret)
+	pseudoRandomKey := extractor.Sum(nil)
+
+	var key, block []byte
+	for counter := byte(1); len(key) < length; counter++ {
+		expander := hmac.New(sha256.New, pseudoRandomKey)
+		expander.Write(block)
+		expander.Write([]byte(info))
+		expander.Write([]byte{counter})
+		block = expander.Sum(nil)
+		key = append(key, block...)
+	}
+
+	return key[:length]
+}